@@ -1,15 +1,20 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
 	"os"
+	"social/internal/auth"
 	"social/internal/db"
 	"social/internal/handlers"
 	"social/internal/rabbit"
+	"social/internal/services"
+	"social/internal/storage"
 	"social/internal/ws"
 	"strconv"
+	"time"
 )
 
 func main() {
@@ -36,6 +41,21 @@ func main() {
 
 	log.Printf("Database configuration: Write: %s:%s, Read: %s:%s", writeHost, writePort, readHost, readPort)
 
+	// Настраиваем подпись токенов
+	authSecret := getEnv("AUTH_SECRET", "")
+	if authSecret == "" {
+		log.Fatal("AUTH_SECRET must be set")
+	}
+	accessTTL, err := time.ParseDuration(getEnv("AUTH_TOKEN_TTL", "15m"))
+	if err != nil {
+		log.Fatalf("Invalid AUTH_TOKEN_TTL: %v", err)
+	}
+	refreshTTL, err := time.ParseDuration(getEnv("AUTH_REFRESH_TTL", "720h"))
+	if err != nil {
+		log.Fatalf("Invalid AUTH_REFRESH_TTL: %v", err)
+	}
+	auth.Init(authSecret, accessTTL, refreshTTL)
+
 	// Init RabbitMQ
 	log.Printf("RABBITMQ_URL: %s", os.Getenv("RABBITMQ_URL"))
 	if err := rabbit.InitRabbit(); err != nil {
@@ -43,10 +63,27 @@ func main() {
 	}
 	defer rabbit.CloseRabbit()
 
+	// Не доставляем события постов заблокированным пользователям
+	ws.SetRecipientFilter(services.ExcludeBlocked)
+
+	// События диалогов доставляются через RabbitMQ на реплику, к которой подключен пользователь
+	if err := services.StartDialogEvents(); err != nil {
+		log.Fatalf("Failed to start dialog events: %v", err)
+	}
+	// Присутствие пользователей и входящие сообщения клиентов (набор текста, ping)
+	ws.SetConnectionHooks(services.UserConnected, services.UserDisconnected)
+	ws.SetInboundHandler(services.HandleClientMessage)
+
+	// Авторы с большим числом подписчиков не рассылаются по лентам, а подмешиваются при чтении
+	celebrityThreshold, err := strconv.Atoi(getEnv("FEED_CELEBRITY_THRESHOLD", "10000"))
+	if err != nil {
+		log.Fatalf("Invalid FEED_CELEBRITY_THRESHOLD: %v", err)
+	}
+	services.SetCelebrityThreshold(celebrityThreshold)
+
 	// Создаем очереди для шардов и запускаем воркеры
-	const numShards = 8
-	for shard := 0; shard < numShards; shard++ {
-		queueName := "feed_shard_" + strconv.Itoa(shard)
+	for shard := 0; shard < services.FeedShards; shard++ {
+		queueName := services.FeedQueueName(shard)
 		_, err := rabbit.RabbitChan.QueueDeclare(queueName, true, false, false, false, nil)
 		if err != nil {
 			log.Fatalf("Failed to declare queue %s: %v", queueName, err)
@@ -54,31 +91,110 @@ func main() {
 		go func(qn string) {
 			msgs, _ := rabbit.RabbitChan.Consume(qn, "", true, false, false, false, nil)
 			for d := range msgs {
-				var task struct {
-					FriendIDs []string                 `json:"friend_ids"`
-					Post      ws.PostFeedPostedMessage `json:"post"`
-				}
+				var task services.FeedTask
 				if err := json.Unmarshal(d.Body, &task); err == nil {
-					ws.NotifyFriendsBatch(task.FriendIDs, task.Post)
+					services.ProcessFeedTask(context.Background(), task)
 				}
 			}
 		}(queueName)
 	}
 
+	// Очередь фонового пересчета рекомендаций друзей
+	if _, err := rabbit.RabbitChan.QueueDeclare(services.SuggestionsQueue, true, false, false, false, nil); err != nil {
+		log.Fatalf("Failed to declare queue %s: %v", services.SuggestionsQueue, err)
+	}
+	go func() {
+		msgs, _ := rabbit.RabbitChan.Consume(services.SuggestionsQueue, "", true, false, false, false, nil)
+		for d := range msgs {
+			var task services.SuggestionsRefreshTask
+			if err := json.Unmarshal(d.Body, &task); err == nil {
+				services.ProcessSuggestionsRefresh(context.Background(), task)
+			}
+		}
+	}()
+
+	// Хранилище медиафайлов: локальный диск или S3-совместимый сервис
+	var mediaStorage storage.Storage
+	switch backend := getEnv("MEDIA_STORAGE", "local"); backend {
+	case "local":
+		mediaStorage, err = storage.NewLocal(getEnv("MEDIA_DIR", "/data/media"))
+	case "s3":
+		mediaStorage, err = storage.NewS3(getEnv("S3_ENDPOINT", ""), getEnv("S3_BUCKET", ""), getEnv("S3_REGION", ""),
+			getEnv("S3_ACCESS_KEY", ""), getEnv("S3_SECRET_KEY", ""))
+	default:
+		log.Fatalf("Unknown MEDIA_STORAGE %q", backend)
+	}
+	if err != nil {
+		log.Fatalf("Failed to init media storage: %v", err)
+	}
+	services.SetMediaStorage(mediaStorage, getEnv("MEDIA_BASE_URL", ""))
+
+	// Фоновая запись счетчиков лайков из Redis в Postgres
+	go services.RunLikeCountFlusher(context.Background())
+
+	// Компенсация незавершенных обновлений счетчиков непрочитанных
+	go services.RunUnreadOutboxRelay(context.Background())
+
+	// Удаление загрузок, так и не прикрепленных к постам
+	go services.RunMediaCleanup(context.Background())
+
 	// Настраиваем HTTP маршруты
 	mux := http.NewServeMux()
 	mux.HandleFunc("/login", handlers.LoginHandler)
+	mux.HandleFunc("POST /token/refresh", handlers.RefreshTokenHandler)
+	mux.HandleFunc("POST /logout", handlers.RequireAuth(handlers.LogoutHandler))
+	mux.HandleFunc("POST /logout/all", handlers.RequireAuth(handlers.LogoutAllHandler))
+	mux.HandleFunc("GET /sessions", handlers.RequireAuth(handlers.ListSessionsHandler))
+	mux.HandleFunc("DELETE /sessions/{session_id}", handlers.RequireAuth(handlers.RevokeSessionHandler))
 	mux.HandleFunc("/user/register", handlers.RegisterHandler)
-	mux.HandleFunc("/user/get/", handlers.GetUserHandler)
-	mux.HandleFunc("/user/search", handlers.SearchUsersHandler)
-	mux.HandleFunc("GET /post/feed", handlers.PostFeedHandler)
-	mux.HandleFunc("POST /post/create", handlers.CreatePostHandler)
-	mux.HandleFunc("POST /dialog/{user_id}/send", handlers.SendMessageHandler)
-	mux.HandleFunc("GET /dialog/{user_id}/list", handlers.GetDialogHandler)
-	mux.HandleFunc("/ws", ws.ServeWS)
+	mux.HandleFunc("GET /user/get/{id}", handlers.OptionalAuth(handlers.GetUserHandler))
+	mux.HandleFunc("/user/search", handlers.OptionalAuth(handlers.SearchUsersHandler))
+	mux.HandleFunc("PUT /user/me", handlers.RequireAuth(handlers.UpdateMeHandler))
+	mux.HandleFunc("POST /user/me/password", handlers.RequireAuth(handlers.ChangePasswordHandler))
+	mux.HandleFunc("DELETE /user/me", handlers.RequireAuth(handlers.DeleteMeHandler))
+	mux.HandleFunc("POST /user/{id}/block", handlers.RequireAuth(handlers.BlockUserHandler))
+	mux.HandleFunc("DELETE /user/{id}/block", handlers.RequireAuth(handlers.UnblockUserHandler))
+	mux.HandleFunc("GET /user/{id}/{resource}", handlers.RequireAuth(handlers.Subresources(map[string]http.HandlerFunc{
+		"presence": handlers.PresenceHandler,
+	})))
+	mux.HandleFunc("GET /user/blocks", handlers.RequireAuth(handlers.ListBlockedUsersHandler))
+	mux.HandleFunc("PUT /friend/set/{user_id}", handlers.RequireAuth(handlers.AddFriendHandler))
+	mux.HandleFunc("PUT /friend/delete/{user_id}", handlers.RequireAuth(handlers.DeleteFriendHandler))
+	mux.HandleFunc("GET /friend/list", handlers.RequireAuth(handlers.ListFriendsHandler))
+	mux.HandleFunc("GET /friend/suggestions", handlers.RequireAuth(handlers.FriendSuggestionsHandler))
+	mux.HandleFunc("POST /friend/request/{user_id}", handlers.RequireAuth(handlers.SendFriendRequestHandler))
+	mux.HandleFunc("POST /friend/request/{request_id}/accept", handlers.RequireAuth(handlers.AcceptFriendRequestHandler))
+	mux.HandleFunc("POST /friend/request/{request_id}/decline", handlers.RequireAuth(handlers.DeclineFriendRequestHandler))
+	mux.HandleFunc("DELETE /friend/request/{request_id}", handlers.RequireAuth(handlers.CancelFriendRequestHandler))
+	mux.HandleFunc("GET /friend/requests/incoming", handlers.RequireAuth(handlers.ListIncomingFriendRequestsHandler))
+	mux.HandleFunc("GET /friend/requests/outgoing", handlers.RequireAuth(handlers.ListOutgoingFriendRequestsHandler))
+	mux.HandleFunc("GET /post/feed", handlers.RequireAuth(handlers.PostFeedHandler))
+	mux.HandleFunc("GET /post/tag/{tag}", handlers.RequireAuth(handlers.TagPostsHandler))
+	mux.HandleFunc("POST /post/create", handlers.RequireAuth(handlers.CreatePostHandler))
+	mux.HandleFunc("GET /post/get/{id}", handlers.RequireAuth(handlers.GetPostHandler))
+	mux.HandleFunc("PUT /post/update", handlers.RequireAuth(handlers.UpdatePostHandler))
+	mux.HandleFunc("PUT /post/delete/{id}", handlers.RequireAuth(handlers.DeletePostHandler))
+	mux.HandleFunc("POST /post/{id}/like", handlers.RequireAuth(handlers.LikePostHandler))
+	mux.HandleFunc("DELETE /post/{id}/like", handlers.RequireAuth(handlers.UnlikePostHandler))
+	mux.HandleFunc("POST /post/{id}/repost", handlers.RequireAuth(handlers.RepostHandler))
+	mux.HandleFunc("POST /post/{id}/comments", handlers.RequireAuth(handlers.CreateCommentHandler))
+	mux.HandleFunc("GET /post/{id}/{resource}", handlers.RequireAuth(handlers.Subresources(map[string]http.HandlerFunc{
+		"likes":    handlers.ListPostLikesHandler,
+		"comments": handlers.ListCommentsHandler,
+	})))
+	mux.HandleFunc("PUT /comment/{id}", handlers.RequireAuth(handlers.UpdateCommentHandler))
+	mux.HandleFunc("DELETE /comment/{id}", handlers.RequireAuth(handlers.DeleteCommentHandler))
+	mux.HandleFunc("POST /media/upload", handlers.RequireAuth(handlers.UploadMediaHandler))
+	mux.HandleFunc("GET /media/{id}", handlers.OptionalAuth(handlers.ServeMediaHandler))
+	mux.HandleFunc("POST /dialog/{user_id}/send", handlers.RequireAuth(handlers.SendMessageHandler))
+	mux.HandleFunc("GET /dialog/list", handlers.RequireAuth(handlers.ListConversationsHandler))
+	mux.HandleFunc("POST /dialog/{user_id}/read", handlers.RequireAuth(handlers.MarkDialogReadHandler))
+	mux.HandleFunc("GET /counters", handlers.RequireAuth(handlers.CountersHandler))
+	mux.HandleFunc("GET /dialog/{user_id}/list", handlers.RequireAuth(handlers.GetDialogHandler))
+	mux.HandleFunc("/ws", handlers.RequireAuth(handlers.WebSocketHandler))
 
 	log.Println("Server starting on port 8080...")
-	log.Fatal(http.ListenAndServe(":8080", mux))
+	log.Fatal(http.ListenAndServe(":8080", handlers.WithRequestID(mux)))
 }
 
 func getEnv(key, fallback string) string {
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"context"
+	"time"
+)
+
+type contextKey int
+
+const (
+	userIDKey contextKey = iota
+	sessionIDKey
+)
+
+var (
+	secret     []byte
+	accessTTL  = 15 * time.Minute
+	refreshTTL = 30 * 24 * time.Hour
+)
+
+// Init configures the signing secret and the lifetimes of access and refresh tokens
+func Init(signingSecret string, accessTokenTTL, refreshTokenTTL time.Duration) {
+	secret = []byte(signingSecret)
+	if accessTokenTTL > 0 {
+		accessTTL = accessTokenTTL
+	}
+	if refreshTokenTTL > 0 {
+		refreshTTL = refreshTokenTTL
+	}
+}
+
+// AccessTokenTTL returns the configured lifetime of access tokens
+func AccessTokenTTL() time.Duration {
+	return accessTTL
+}
+
+// RefreshTokenTTL returns the configured lifetime of refresh tokens and their sessions
+func RefreshTokenTTL() time.Duration {
+	return refreshTTL
+}
+
+// WithSession returns a copy of ctx carrying the authenticated user and session IDs
+func WithSession(ctx context.Context, userID, sessionID string) context.Context {
+	ctx = context.WithValue(ctx, userIDKey, userID)
+	return context.WithValue(ctx, sessionIDKey, sessionID)
+}
+
+// UserIDFromContext returns the authenticated user ID stored by the auth middleware
+func UserIDFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value(userIDKey).(string)
+	return userID
+}
+
+// SessionIDFromContext returns the session ID of the token used for the request
+func SessionIDFromContext(ctx context.Context) string {
+	sessionID, _ := ctx.Value(sessionIDKey).(string)
+	return sessionID
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"social/internal/errors"
+	"strings"
+)
+
+// NewRefreshToken creates an opaque refresh token bound to a session.
+// Only the returned hash should be stored server-side.
+func NewRefreshToken(sessionID string) (token, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	random := base64.RawURLEncoding.EncodeToString(buf)
+	return sessionID + "." + random, hashRefreshSecret(random), nil
+}
+
+// ParseRefreshToken splits a refresh token into its session ID and the hash of its secret part
+func ParseRefreshToken(token string) (sessionID, hash string, err error) {
+	sessionID, random, ok := strings.Cut(token, ".")
+	if !ok || sessionID == "" || random == "" {
+		return "", "", errors.ErrInvalidToken
+	}
+	return sessionID, hashRefreshSecret(random), nil
+}
+
+func hashRefreshSecret(random string) string {
+	sum := sha256.Sum256([]byte(random))
+	return hex.EncodeToString(sum[:])
+}
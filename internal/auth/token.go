@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"social/internal/errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Claims is the payload of a signed access token
+type Claims struct {
+	ID        string `json:"jti"`
+	SessionID string `json:"sid"`
+	UserID    string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// header is the fixed JWT header; only HS256 is issued and accepted
+var header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// IssueToken creates an HMAC-SHA256 signed access token for the given user session
+func IssueToken(userID, sessionID string) (string, *Claims, error) {
+	if len(secret) == 0 {
+		return "", nil, errors.ErrAuthNotConfigured
+	}
+	now := time.Now()
+	claims := &Claims{
+		ID:        uuid.NewString(),
+		SessionID: sessionID,
+		UserID:    userID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(accessTTL).Unix(),
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + sign(unsigned), claims, nil
+}
+
+// ParseToken verifies the signature and expiry of a token and returns its claims
+func ParseToken(token string) (*Claims, error) {
+	if len(secret) == 0 {
+		return nil, errors.ErrAuthNotConfigured
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != header {
+		return nil, errors.ErrInvalidToken
+	}
+	expected := sign(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(parts[2]), []byte(expected)) {
+		return nil, errors.ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.ErrInvalidToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.UserID == "" || claims.SessionID == "" {
+		return nil, errors.ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, errors.ErrTokenExpired
+	}
+	return &claims, nil
+}
+
+func sign(unsigned string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
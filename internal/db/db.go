@@ -86,6 +86,13 @@ func InitDB(writeHost, writePort, readHost, readPort, citusHost, citusPort, user
 	if err := createMessagesTable(); err != nil {
 		log.Fatalf("Failed to create messages table: %v", err)
 	}
+	// Создаем индекс диалогов пользователей
+	if err := createConversationsTable(); err != nil {
+		log.Fatalf("Failed to create conversations table: %v", err)
+	}
+	if err := createUnreadOutboxTable(); err != nil {
+		log.Fatalf("Failed to create unread outbox table: %v", err)
+	}
 }
 
 // buildDSN формирует строку подключения к PostgreSQL
@@ -118,11 +125,105 @@ func CreateTables() {
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 	);
 
+	ALTER TABLE posts ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP;
+	ALTER TABLE posts ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+	ALTER TABLE posts ADD COLUMN IF NOT EXISTS like_count INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE posts ADD COLUMN IF NOT EXISTS comment_count INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE posts ADD COLUMN IF NOT EXISTS repost_count INTEGER NOT NULL DEFAULT 0;
+	-- No foreign key: reposts outlive the original, which may be removed with its author's account
+	ALTER TABLE posts ADD COLUMN IF NOT EXISTS repost_of UUID;
+
+	CREATE INDEX IF NOT EXISTS posts_author_created_idx ON posts (author_user_id, created_at DESC, id DESC);
+
 	CREATE TABLE IF NOT EXISTS friends (
 		user_id UUID REFERENCES users(id),
 		friend_id UUID REFERENCES users(id),
 		PRIMARY KEY (user_id, friend_id)
 	);
+
+	CREATE TABLE IF NOT EXISTS friend_requests (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		from_user_id UUID NOT NULL REFERENCES users(id),
+		to_user_id UUID NOT NULL REFERENCES users(id),
+		status TEXT NOT NULL DEFAULT 'pending',
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- At most one pending request per pair of users, whichever of them sent it
+	CREATE UNIQUE INDEX IF NOT EXISTS friend_requests_pending_pair_idx
+		ON friend_requests (LEAST(from_user_id, to_user_id), GREATEST(from_user_id, to_user_id))
+		WHERE status = 'pending';
+	CREATE INDEX IF NOT EXISTS friend_requests_to_user_idx ON friend_requests (to_user_id, status);
+
+	CREATE TABLE IF NOT EXISTS blocks (
+		blocker_id UUID NOT NULL REFERENCES users(id),
+		blocked_id UUID NOT NULL REFERENCES users(id),
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (blocker_id, blocked_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS blocks_blocked_idx ON blocks (blocked_id);
+
+	CREATE TABLE IF NOT EXISTS post_likes (
+		post_id UUID NOT NULL REFERENCES posts(id),
+		user_id UUID NOT NULL REFERENCES users(id),
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (post_id, user_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS post_likes_user_idx ON post_likes (user_id, post_id);
+
+	CREATE TABLE IF NOT EXISTS post_tags (
+		post_id UUID NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+		tag TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (post_id, tag)
+	);
+
+	CREATE INDEX IF NOT EXISTS post_tags_tag_idx ON post_tags (tag, created_at DESC, post_id DESC);
+
+	CREATE TABLE IF NOT EXISTS post_mentions (
+		post_id UUID NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+		user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		PRIMARY KEY (post_id, user_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS post_mentions_user_idx ON post_mentions (user_id);
+
+	CREATE TABLE IF NOT EXISTS media (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		owner_user_id UUID NOT NULL REFERENCES users(id),
+		post_id UUID REFERENCES posts(id) ON DELETE SET NULL,
+		position INTEGER,
+		content_type TEXT NOT NULL,
+		size_bytes BIGINT NOT NULL,
+		width INTEGER,
+		height INTEGER,
+		stored BOOLEAN NOT NULL DEFAULT false,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS media_post_idx ON media (post_id, position);
+	CREATE INDEX IF NOT EXISTS media_owner_idx ON media (owner_user_id);
+	CREATE INDEX IF NOT EXISTS media_unattached_idx ON media (created_at) WHERE post_id IS NULL;
+
+	CREATE TABLE IF NOT EXISTS comments (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		post_id UUID NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+		parent_id UUID REFERENCES comments(id) ON DELETE CASCADE,
+		author_user_id UUID NOT NULL REFERENCES users(id),
+		text TEXT NOT NULL,
+		reply_count INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP,
+		deleted_at TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS comments_thread_idx ON comments (post_id, parent_id, created_at, id);
+	CREATE INDEX IF NOT EXISTS comments_parent_idx ON comments (parent_id);
+	CREATE INDEX IF NOT EXISTS comments_author_idx ON comments (author_user_id);
 	`
 	// Используем WriteDB для создания таблиц
 	_, err := WriteDB.Exec(query)
@@ -169,6 +270,13 @@ func createMessagesTable() error {
 		log.Printf("Messages table is already distributed")
 	}
 
+	// Dialog history is paged by (created_at, id) within a shard_key
+	indexQuery := `CREATE INDEX IF NOT EXISTS messages_dialog_idx ON messages (shard_key, created_at, id);`
+	if _, err := CitusDB.Exec(indexQuery); err != nil {
+		log.Printf("Failed to create messages index: %v", err)
+		return err
+	}
+
 	return nil
 }
 
@@ -184,3 +292,104 @@ func Close() {
 		CitusDB.Close()
 	}
 }
+
+// createConversationsTable создает индекс диалогов: по строке на каждую сторону переписки.
+// Таблица распределена по user_id, чтобы список диалогов пользователя читался с одного шарда.
+func createConversationsTable() error {
+	var existing sql.NullString
+	if err := CitusDB.QueryRow(`SELECT to_regclass('conversations');`).Scan(&existing); err != nil {
+		log.Printf("Failed to check conversations table: %v", err)
+		return err
+	}
+	if !existing.Valid {
+		if err := createAndBackfillConversations(); err != nil {
+			return err
+		}
+	}
+
+	// Отметки о прочтении: до какого сообщения прочитал пользователь и до какого собеседник
+	alterQuery := `
+		ALTER TABLE conversations
+			ADD COLUMN IF NOT EXISTS last_read_message_id BIGINT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS partner_read_message_id BIGINT NOT NULL DEFAULT 0;
+	`
+	if _, err := CitusDB.Exec(alterQuery); err != nil {
+		log.Printf("Failed to add read receipts to conversations table: %v", err)
+		return err
+	}
+	return nil
+}
+
+func createAndBackfillConversations() error {
+	query := `
+		CREATE TABLE conversations (
+			user_id UUID NOT NULL,
+			partner_id UUID NOT NULL,
+			last_message_id BIGINT NOT NULL,
+			last_from_user_id UUID NOT NULL,
+			last_text TEXT NOT NULL,
+			last_message_at TIMESTAMP NOT NULL,
+			unread_count INT NOT NULL DEFAULT 0,
+			PRIMARY KEY (user_id, partner_id)
+		);
+		CREATE INDEX conversations_recent_idx ON conversations (user_id, last_message_at DESC, partner_id DESC);
+	`
+	if _, err := CitusDB.Exec(query); err != nil {
+		log.Printf("Failed to create conversations table: %v", err)
+		return err
+	}
+	if _, err := CitusDB.Exec(`SELECT create_distributed_table('conversations', 'user_id');`); err != nil {
+		log.Printf("Failed to distribute conversations table: %v", err)
+		return err
+	}
+
+	// Заполняем индекс по уже существующим сообщениям; прошлые сообщения считаются прочитанными
+	backfillQuery := `
+		INSERT INTO conversations (user_id, partner_id, last_message_id, last_from_user_id, last_text, last_message_at)
+		SELECT DISTINCT ON (owner_id, partner_id) owner_id, partner_id, id, from_user_id, text, created_at
+		FROM (
+			SELECT from_user_id AS owner_id, to_user_id AS partner_id, id, from_user_id, text, created_at FROM messages
+			UNION ALL
+			SELECT to_user_id, from_user_id, id, from_user_id, text, created_at FROM messages
+		) sides
+		ORDER BY owner_id, partner_id, created_at DESC, id DESC;
+	`
+	if _, err := CitusDB.Exec(backfillQuery); err != nil {
+		log.Printf("Failed to backfill conversations table: %v", err)
+		return err
+	}
+	log.Printf("Created and distributed conversations table")
+	return nil
+}
+
+// createUnreadOutboxTable создает outbox изменений счетчиков непрочитанных. Запись добавляется в одной
+// транзакции с сообщением или отметкой о прочтении и удаляется после сброса счетчиков в Redis.
+// Таблица размещена на тех же шардах, что и conversations.
+func createUnreadOutboxTable() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS unread_outbox (
+			user_id UUID NOT NULL,
+			id UUID NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (user_id, id)
+		);
+	`
+	if _, err := CitusDB.Exec(query); err != nil {
+		log.Printf("Failed to create unread outbox table: %v", err)
+		return err
+	}
+
+	checkQuery := `SELECT logicalrelid FROM pg_dist_partition WHERE logicalrelid = 'unread_outbox'::regclass;`
+	var logicalRelID string
+	if err := CitusDB.QueryRow(checkQuery).Scan(&logicalRelID); err == sql.ErrNoRows {
+		distributeQuery := `SELECT create_distributed_table('unread_outbox', 'user_id', colocate_with => 'conversations');`
+		if _, err := CitusDB.Exec(distributeQuery); err != nil {
+			log.Printf("Failed to distribute unread outbox table: %v", err)
+			return err
+		}
+	} else if err != nil {
+		log.Printf("Failed to check distribution status of unread outbox table: %v", err)
+		return err
+	}
+	return nil
+}
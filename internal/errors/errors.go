@@ -1,8 +1,101 @@
 package errors
 
-import "errors"
+import (
+	"errors"
+	"net/http"
+)
+
+// Error is an application error with a stable machine-readable code and the HTTP status it maps to
+type Error struct {
+	Code    string
+	Message string
+	Status  int
+	cause   error
+}
+
+// New creates an application error
+func New(code string, status int, message string) *Error {
+	return &Error{Code: code, Message: message, Status: status}
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return e.Message + ": " + e.cause.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is matches errors by code, so wrapped copies compare equal to their sentinel
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Wrap returns a copy of e that carries the underlying cause for logging
+func (e *Error) Wrap(cause error) *Error {
+	wrapped := *e
+	wrapped.cause = cause
+	return &wrapped
+}
+
+// As returns the application error in err's chain, if any
+func As(err error) (*Error, bool) {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr, true
+	}
+	return nil, false
+}
+
+// Is reports whether any error in err's chain matches target
+func Is(err, target error) bool {
+	return errors.Is(err, target)
+}
 
 var (
-	ErrInvalidCredentials = errors.New("invalid credentials")
-	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidRequest    = New("invalid_request", http.StatusBadRequest, "invalid request")
+	ErrValidation        = New("validation_failed", http.StatusBadRequest, "validation failed")
+	ErrInvalidUUID       = New("invalid_uuid", http.StatusBadRequest, "invalid UUID format")
+	ErrUnauthorized      = New("unauthorized", http.StatusUnauthorized, "authentication required")
+	ErrNotFound          = New("not_found", http.StatusNotFound, "not found")
+	ErrMethodNotAllowed  = New("method_not_allowed", http.StatusMethodNotAllowed, "method not allowed")
+	ErrInternal          = New("internal_error", http.StatusInternalServerError, "internal server error")
+	ErrAuthNotConfigured = New("auth_not_configured", http.StatusInternalServerError, "auth secret is not configured")
+
+	ErrInvalidCredentials = New("invalid_credentials", http.StatusUnauthorized, "invalid credentials")
+	ErrInvalidPassword    = New("invalid_password", http.StatusForbidden, "old password is incorrect")
+	ErrUserNotFound       = New("user_not_found", http.StatusNotFound, "user not found")
+	ErrInvalidToken       = New("invalid_token", http.StatusUnauthorized, "invalid token")
+	ErrTokenExpired       = New("token_expired", http.StatusUnauthorized, "token expired")
+	ErrSessionRevoked     = New("session_revoked", http.StatusUnauthorized, "session revoked")
+	ErrSessionNotFound    = New("session_not_found", http.StatusNotFound, "session not found")
+	ErrRefreshTokenReused = New("refresh_token_reused", http.StatusUnauthorized, "refresh token reused")
+
+	ErrCannotFriendSelf      = New("cannot_friend_self", http.StatusBadRequest, "cannot add yourself as a friend")
+	ErrFriendNotFound        = New("friend_not_found", http.StatusNotFound, "friend not found")
+	ErrAlreadyFriends        = New("already_friends", http.StatusConflict, "already friends")
+	ErrRequestExists         = New("friend_request_exists", http.StatusConflict, "friend request already pending")
+	ErrRequestNotFound       = New("friend_request_not_found", http.StatusNotFound, "friend request not found")
+	ErrFriendRequestRequired = New("friend_request_required", http.StatusForbidden, "friendship requires an accepted friend request")
+	ErrCannotBlockSelf       = New("cannot_block_self", http.StatusBadRequest, "cannot block yourself")
+	ErrUserBlocked           = New("user_blocked", http.StatusForbidden, "user is blocked")
+	ErrBlockNotFound         = New("block_not_found", http.StatusNotFound, "block not found")
+
+	ErrPostNotFound  = New("post_not_found", http.StatusNotFound, "post not found")
+	ErrNotPostAuthor = New("not_post_author", http.StatusForbidden, "only the author can modify the post")
+
+	ErrMessageNotFound   = New("message_not_found", http.StatusNotFound, "message not found")
+	ErrCannotMessageSelf = New("cannot_message_self", http.StatusBadRequest, "cannot send a message to yourself")
+
+	ErrMediaNotFound    = New("media_not_found", http.StatusNotFound, "media not found")
+	ErrMediaTooLarge    = New("media_too_large", http.StatusRequestEntityTooLarge, "media file is too large")
+	ErrMediaEmpty       = New("media_empty", http.StatusBadRequest, "media file is empty")
+	ErrUnsupportedMedia = New("unsupported_media", http.StatusUnsupportedMediaType, "unsupported media type")
+
+	ErrCommentNotFound  = New("comment_not_found", http.StatusNotFound, "comment not found")
+	ErrNotCommentAuthor = New("not_comment_author", http.StatusForbidden, "only the author can modify the comment")
 )
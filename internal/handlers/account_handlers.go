@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"social/internal/auth"
+	"social/internal/models"
+	"social/internal/services"
+	"social/internal/validation"
+)
+
+func UpdateMeHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	var update models.UserUpdate
+	if !readJSON(w, r, &update, func() error { return validation.UserUpdate(&update) }) {
+		return
+	}
+
+	user, err := services.UpdateUser(r.Context(), userID, &update)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+func ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	var change models.PasswordChange
+	if !readJSON(w, r, &change, func() error { return validation.PasswordChange(&change) }) {
+		return
+	}
+
+	if err := services.ChangePassword(r.Context(), userID, auth.SessionIDFromContext(r.Context()), &change); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func DeleteMeHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	if err := services.DeleteUser(r.Context(), userID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+	"social/internal/auth"
+	"social/internal/services"
+	"social/internal/validation"
+)
+
+func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	postID, ok := pathUUID(w, r, "id")
+	if !ok {
+		return
+	}
+	var payload struct {
+		ParentID string `json:"parent_id"`
+		Text     string `json:"text"`
+	}
+	if !readJSON(w, r, &payload, func() error {
+		var v validation.Validator
+		if payload.ParentID != "" {
+			v.UUID("parent_id", payload.ParentID)
+		}
+		if v.Required("text", payload.Text) {
+			v.MaxLength("text", payload.Text, validation.MaxCommentLength)
+		}
+		return v.Err()
+	}) {
+		return
+	}
+
+	comment, err := services.CreateComment(r.Context(), auth.UserIDFromContext(r.Context()), postID, payload.ParentID, payload.Text)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, comment)
+}
+
+func ListCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	postID, ok := pathUUID(w, r, "id")
+	if !ok {
+		return
+	}
+	parentID := r.URL.Query().Get("parent_id")
+	if parentID != "" {
+		var v validation.Validator
+		v.UUID("parent_id", parentID)
+		if err := v.Err(); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	_, limit, err := parsePagination(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	cursor, err := parseCursor(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	page, err := services.ListComments(r.Context(), auth.UserIDFromContext(r.Context()), postID, parentID, cursor, limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func UpdateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	commentID, ok := pathUUID(w, r, "id")
+	if !ok {
+		return
+	}
+	var payload struct {
+		Text string `json:"text"`
+	}
+	if !readJSON(w, r, &payload, func() error {
+		return validation.Text("text", payload.Text, validation.MaxCommentLength)
+	}) {
+		return
+	}
+
+	comment, err := services.UpdateComment(r.Context(), auth.UserIDFromContext(r.Context()), commentID, payload.Text)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, comment)
+}
+
+func DeleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	commentID, ok := pathUUID(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := services.DeleteComment(r.Context(), auth.UserIDFromContext(r.Context()), commentID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
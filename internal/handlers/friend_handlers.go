@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"social/internal/auth"
+	"social/internal/services"
+)
+
+func AddFriendHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	friendID, ok := pathUUID(w, r, "user_id")
+	if !ok {
+		return
+	}
+
+	if err := services.AddFriend(r.Context(), userID, friendID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func DeleteFriendHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	friendID, ok := pathUUID(w, r, "user_id")
+	if !ok {
+		return
+	}
+
+	if err := services.RemoveFriend(r.Context(), userID, friendID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func ListFriendsHandler(w http.ResponseWriter, r *http.Request) {
+	listPage(w, r, services.ListFriends)
+}
+
+func FriendSuggestionsHandler(w http.ResponseWriter, r *http.Request) {
+	listPage(w, r, services.GetFriendSuggestions)
+}
+
+func SendFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	toUserID, ok := pathUUID(w, r, "user_id")
+	if !ok {
+		return
+	}
+
+	req, err := services.SendFriendRequest(r.Context(), userID, toUserID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, req)
+}
+
+func AcceptFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
+	handleFriendRequestAction(w, r, services.AcceptFriendRequest)
+}
+
+func DeclineFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
+	handleFriendRequestAction(w, r, services.DeclineFriendRequest)
+}
+
+func CancelFriendRequestHandler(w http.ResponseWriter, r *http.Request) {
+	handleFriendRequestAction(w, r, services.CancelFriendRequest)
+}
+
+func handleFriendRequestAction(w http.ResponseWriter, r *http.Request, action func(context.Context, string, string) error) {
+	userID := auth.UserIDFromContext(r.Context())
+	requestID, ok := pathUUID(w, r, "request_id")
+	if !ok {
+		return
+	}
+
+	if err := action(r.Context(), userID, requestID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func ListIncomingFriendRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	listPage(w, r, services.ListIncomingFriendRequests)
+}
+
+func ListOutgoingFriendRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	listPage(w, r, services.ListOutgoingFriendRequests)
+}
+
+func BlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	blockedID, ok := pathUUID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	if err := services.BlockUser(r.Context(), userID, blockedID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func UnblockUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	blockedID, ok := pathUUID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	if err := services.UnblockUser(r.Context(), userID, blockedID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func ListBlockedUsersHandler(w http.ResponseWriter, r *http.Request) {
+	listPage(w, r, services.ListBlockedUsers)
+}
+
+// listPage serves an offset-paginated list owned by the authenticated user
+func listPage[T any](w http.ResponseWriter, r *http.Request, list func(context.Context, string, int, int) ([]T, error)) {
+	userID := auth.UserIDFromContext(r.Context())
+	offset, limit, err := parsePagination(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	items, err := list(r.Context(), userID, offset, limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
@@ -1,86 +1,78 @@
 package handlers
 
 import (
-	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"social/internal/auth"
 	"social/internal/errors"
 	"social/internal/models"
-	"social/internal/rabbit"
 	"social/internal/services"
+	"social/internal/validation"
+	"social/internal/ws"
 	"strconv"
-	"strings"
+)
 
-	"github.com/google/uuid"
-	"github.com/streadway/amqp"
+const (
+	defaultMutualFriendsPreview = 3
+	maxMutualFriendsPreview     = 10
 )
 
 func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	var user models.User
-	err := json.NewDecoder(r.Body).Decode(&user)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !readJSON(w, r, &user, func() error { return validation.User(&user) }) {
 		return
 	}
 	userID, err := services.RegisterUser(&user)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	json.NewEncoder(w).Encode(map[string]string{"user_id": userID})
-	w.WriteHeader(http.StatusOK)
+	writeJSON(w, http.StatusOK, map[string]string{"user_id": userID})
 }
 
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	var credentials models.Credentials
-	err := json.NewDecoder(r.Body).Decode(&credentials)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	// Validate that ID is a UUID and password is not empty
-	if _, err := uuid.Parse(credentials.ID); err != nil {
-		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
-		return
-	}
-	if credentials.Password == "" {
-		http.Error(w, "Password cannot be empty", http.StatusBadRequest)
+	if !readJSON(w, r, &credentials, func() error { return validation.Credentials(&credentials) }) {
 		return
 	}
-	token, err := services.LoginUser(&credentials)
+	tokens, err := services.LoginUser(r.Context(), &credentials, r.UserAgent(), clientIP(r))
 	if err != nil {
-		switch err {
-		case errors.ErrInvalidCredentials:
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-		case errors.ErrUserNotFound:
-			http.Error(w, err.Error(), http.StatusNotFound)
-		default:
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+		writeError(w, r, err)
 		return
 	}
-	json.NewEncoder(w).Encode(map[string]string{"token": token})
+	writeJSON(w, http.StatusOK, tokens)
 }
 
 func GetUserHandler(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/user/get/")
+	id := r.PathValue("id")
 	log.Printf("GetUserHandler: received request for user ID: %s", id)
 
-	user, err := services.GetUserByID(id)
+	mutualLimit := defaultMutualFriendsPreview
+	if val := r.URL.Query().Get("mutual_limit"); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			writeError(w, r, validation.Errors{{Field: "mutual_limit", Message: "must be a non-negative integer"}})
+			return
+		}
+		mutualLimit = min(n, maxMutualFriendsPreview)
+	}
+
+	user, err := services.GetUserView(r.Context(), auth.UserIDFromContext(r.Context()), id, mutualLimit)
 	if err != nil {
 		log.Printf("GetUserHandler: error getting user by ID %s: %v", id, err)
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeError(w, r, err)
 		return
 	}
 
 	log.Printf("GetUserHandler: successfully retrieved user with ID: %s", id)
-	json.NewEncoder(w).Encode(user)
+	writeJSON(w, http.StatusOK, user)
 }
 
 func SearchUsersHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		log.Printf("SearchUsersHandler: invalid method %s", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, errors.ErrMethodNotAllowed)
 		return
 	}
 
@@ -91,191 +83,335 @@ func SearchUsersHandler(w http.ResponseWriter, r *http.Request) {
 
 	if firstName == "" || lastName == "" {
 		log.Printf("SearchUsersHandler: missing required parameters. first_name='%s', last_name='%s'", firstName, lastName)
-		http.Error(w, "Both first_name and last_name parameters are required", http.StatusBadRequest)
+		var v validation.Validator
+		v.Required("first_name", firstName)
+		v.Required("last_name", lastName)
+		writeError(w, r, v.Err())
 		return
 	}
 
-	users, err := services.SearchUsers(firstName, lastName)
+	users, err := services.SearchUsers(firstName, lastName, auth.UserIDFromContext(r.Context()))
 	if err != nil {
 		log.Printf("SearchUsersHandler: error searching users with first_name='%s', last_name='%s': %v", firstName, lastName, err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	log.Printf("SearchUsersHandler: found %d users for first_name='%s', last_name='%s'", len(users), firstName, lastName)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(users)
+	writeJSON(w, http.StatusOK, users)
 }
 
 func PostFeedHandler(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("User-Id")
+	userID := auth.UserIDFromContext(r.Context())
 	if userID == "" {
-		http.Error(w, "User-Id header is required", http.StatusBadRequest)
+		writeError(w, r, errors.ErrUnauthorized)
 		return
 	}
 
-	offset, limit := 0, 10
-	if val := r.URL.Query().Get("offset"); val != "" {
-		offset, _ = strconv.Atoi(val)
+	offset, limit, err := parsePagination(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
-	if val := r.URL.Query().Get("limit"); val != "" {
-		limit, _ = strconv.Atoi(val)
+	cursor, err := parseCursor(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
 
-	posts, err := services.GetFriendPosts(r.Context(), userID, offset, limit)
+	var page models.PostPage
+	if cursor == nil && r.URL.Query().Has("offset") {
+		// Offset paging is kept for old clients only
+		w.Header().Set("Deprecation", "true")
+		page, err = services.GetFriendPostsByOffset(r.Context(), userID, offset, limit)
+	} else {
+		page, err = services.GetFriendPosts(r.Context(), userID, cursor, limit)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+	writeJSON(w, http.StatusOK, page)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(posts)
+func TagPostsHandler(w http.ResponseWriter, r *http.Request) {
+	tag := services.NormalizeTag(r.PathValue("tag"))
+	if tag == "" {
+		writeError(w, r, validation.Errors{{Field: "tag", Message: "must contain only letters, digits and underscores"}})
+		return
+	}
+	_, limit, err := parsePagination(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	cursor, err := parseCursor(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	page, err := services.GetTagPosts(r.Context(), auth.UserIDFromContext(r.Context()), tag, cursor, limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
 }
 
 func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
-	fromUserID := r.Header.Get("User-Id")
+	fromUserID := auth.UserIDFromContext(r.Context())
 	if fromUserID == "" {
-		http.Error(w, "User-Id header is required", http.StatusBadRequest)
+		writeError(w, r, errors.ErrUnauthorized)
 		return
 	}
 
-	toUserID := r.PathValue("user_id")
-	if toUserID == "" {
-		http.Error(w, "Recipient user ID is required", http.StatusBadRequest)
+	toUserID, ok := pathUUID(w, r, "user_id")
+	if !ok {
+		return
+	}
+	if toUserID == fromUserID {
+		writeError(w, r, errors.ErrCannotMessageSelf)
 		return
 	}
 
 	var payload struct {
 		Text string `json:"text"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !readJSON(w, r, &payload, func() error {
+		return validation.Text("text", payload.Text, validation.MaxMessageLength)
+	}) {
+		return
+	}
+
+	message, err := services.SendMessage(r.Context(), fromUserID, toUserID, payload.Text)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, message)
+}
+
+func GetDialogHandler(w http.ResponseWriter, r *http.Request) {
+	userID1 := auth.UserIDFromContext(r.Context())
+	if userID1 == "" {
+		writeError(w, r, errors.ErrUnauthorized)
 		return
 	}
 
-	if payload.Text == "" {
-		http.Error(w, "Message text cannot be empty", http.StatusBadRequest)
+	userID2, ok := pathUUID(w, r, "user_id")
+	if !ok {
 		return
 	}
 
-	err := services.SendMessage(fromUserID, toUserID, payload.Text)
+	_, limit, err := parsePagination(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	before, after, err := parseMessageCursors(r)
 	if err != nil {
-		http.Error(w, "Failed to send message", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	page, err := services.GetDialog(r.Context(), userID1, userID2, before, after, limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
 }
 
-func GetDialogHandler(w http.ResponseWriter, r *http.Request) {
-	userID1 := r.Header.Get("User-Id")
-	if userID1 == "" {
-		http.Error(w, "User-Id header is required", http.StatusBadRequest)
+func PresenceHandler(w http.ResponseWriter, r *http.Request) {
+	viewerID := auth.UserIDFromContext(r.Context())
+	if viewerID == "" {
+		writeError(w, r, errors.ErrUnauthorized)
+		return
+	}
+	userID, ok := pathUUID(w, r, "id")
+	if !ok {
+		return
+	}
+	presence, err := services.GetPresence(r.Context(), viewerID, userID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, presence)
+}
+
+// WebSocketHandler upgrades the caller's request to a WebSocket carrying their real-time events
+func WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	if userID == "" {
+		writeError(w, r, errors.ErrUnauthorized)
+		return
+	}
+	ws.ServeWS(w, r, userID, auth.SessionIDFromContext(r.Context()))
+}
+
+func MarkDialogReadHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	if userID == "" {
+		writeError(w, r, errors.ErrUnauthorized)
+		return
+	}
+	partnerID, ok := pathUUID(w, r, "user_id")
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		MessageID int64 `json:"message_id"`
+	}
+	if !readJSON(w, r, &payload, func() error {
+		var v validation.Validator
+		if payload.MessageID <= 0 {
+			v.Add("message_id", "must be a positive integer")
+		}
+		return v.Err()
+	}) {
 		return
 	}
 
-	userID2 := r.PathValue("user_id")
-	if userID2 == "" {
-		http.Error(w, "Other user ID is required", http.StatusBadRequest)
+	if err := services.MarkDialogRead(r.Context(), userID, partnerID, payload.MessageID); err != nil {
+		writeError(w, r, err)
 		return
 	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	messages, err := services.GetDialog(userID1, userID2)
+func CountersHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	if userID == "" {
+		writeError(w, r, errors.ErrUnauthorized)
+		return
+	}
+	counters, err := services.GetCounters(r.Context(), userID)
 	if err != nil {
-		http.Error(w, "Failed to retrieve dialog", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+	writeJSON(w, http.StatusOK, counters)
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(messages)
+func ListConversationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	if userID == "" {
+		writeError(w, r, errors.ErrUnauthorized)
+		return
+	}
+	_, limit, err := parsePagination(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	cursor, err := parseCursor(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	page, err := services.ListConversations(r.Context(), userID, cursor, limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
 }
 
 func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, errors.ErrMethodNotAllowed)
 		return
 	}
-	userID := r.Header.Get("User-Id")
+	userID := auth.UserIDFromContext(r.Context())
 	if userID == "" {
-		http.Error(w, "User-Id header is required", http.StatusUnauthorized)
+		writeError(w, r, errors.ErrUnauthorized)
 		return
 	}
 	var payload struct {
-		Text string `json:"text"`
+		Text          string   `json:"text"`
+		AttachmentIDs []string `json:"attachment_ids"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !readJSON(w, r, &payload, func() error {
+		var v validation.Validator
+		// A post with attachments may go without text
+		if len(payload.AttachmentIDs) > 0 || v.Required("text", payload.Text) {
+			v.MaxLength("text", payload.Text, validation.MaxPostLength)
+		}
+		if len(payload.AttachmentIDs) > services.MaxAttachments {
+			v.Add("attachment_ids", fmt.Sprintf("must contain at most %d items", services.MaxAttachments))
+		}
+		for _, id := range payload.AttachmentIDs {
+			if !v.UUID("attachment_ids", id) {
+				break
+			}
+		}
+		return v.Err()
+	}) {
 		return
 	}
-	if payload.Text == "" {
-		http.Error(w, "Text is required", http.StatusBadRequest)
+	postID, err := services.CreatePost(userID, payload.Text, payload.AttachmentIDs)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"id": postID})
+}
+
+func GetPostHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	postID, ok := pathUUID(w, r, "id")
+	if !ok {
 		return
 	}
-	postID, err := services.CreatePost(userID, payload.Text)
+
+	post, err := services.GetPost(r.Context(), userID, postID)
 	if err != nil {
-		http.Error(w, "Failed to create post", http.StatusInternalServerError)
-		return
-	}
-	// Шардинг: публикуем задачи в очереди feed_shard_{N}
-	go func() {
-		friendIDs, _ := services.GetFriendIDs(userID)
-		const batchSize = 100
-		const numShards = 8
-		for i := 0; i < len(friendIDs); i += batchSize {
-			end := i + batchSize
-			if end > len(friendIDs) {
-				end = len(friendIDs)
-			}
-			batch := friendIDs[i:end]
-			// Группируем по шардам
-			shardBatches := make(map[int][]string)
-			for _, fid := range batch {
-				shard := int(hashString(fid)) % numShards
-				shardBatches[shard] = append(shardBatches[shard], fid)
-			}
-			for shard, shardFriendIDs := range shardBatches {
-				task := struct {
-					FriendIDs []string `json:"friend_ids"`
-					Post      struct {
-						PostID       string `json:"postId"`
-						PostText     string `json:"postText"`
-						AuthorUserID string `json:"author_user_id"`
-					} `json:"post"`
-				}{
-					FriendIDs: shardFriendIDs,
-					Post: struct {
-						PostID       string `json:"postId"`
-						PostText     string `json:"postText"`
-						AuthorUserID string `json:"author_user_id"`
-					}{
-						PostID:       postID,
-						PostText:     payload.Text,
-						AuthorUserID: userID,
-					},
-				}
-				body, _ := json.Marshal(task)
-				queueName := "feed_shard_" + strconv.Itoa(shard)
-				rabbit.RabbitChan.Publish(
-					"",        // default exchange
-					queueName, // routing key = имя очереди
-					false, false,
-					amqp.Publishing{
-						ContentType: "application/json",
-						Body:        body,
-					},
-				)
-			}
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, post)
+}
+
+func UpdatePostHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	var payload struct {
+		ID   string `json:"id"`
+		Text string `json:"text"`
+	}
+	if !readJSON(w, r, &payload, func() error {
+		var v validation.Validator
+		if v.Required("id", payload.ID) {
+			v.UUID("id", payload.ID)
 		}
-	}()
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"id": postID})
+		// Like on create, a post with attachments may go without text; the service checks the stored post
+		v.MaxLength("text", payload.Text, validation.MaxPostLength)
+		return v.Err()
+	}) {
+		return
+	}
+
+	post, err := services.UpdatePost(r.Context(), userID, payload.ID, payload.Text)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, post)
 }
 
-// hashString - простая хеш-функция для строк (userID)
-func hashString(s string) uint32 {
-	var h uint32 = 2166136261
-	for i := 0; i < len(s); i++ {
-		h = (h * 16777619) ^ uint32(s[i])
+func DeletePostHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	postID, ok := pathUUID(w, r, "id")
+	if !ok {
+		return
 	}
-	return h
+
+	if err := services.DeletePost(r.Context(), userID, postID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
 }
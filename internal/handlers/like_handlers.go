@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+	"social/internal/auth"
+	"social/internal/services"
+)
+
+func LikePostHandler(w http.ResponseWriter, r *http.Request) {
+	postID, ok := pathUUID(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := services.LikePost(r.Context(), auth.UserIDFromContext(r.Context()), postID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func UnlikePostHandler(w http.ResponseWriter, r *http.Request) {
+	postID, ok := pathUUID(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := services.UnlikePost(r.Context(), auth.UserIDFromContext(r.Context()), postID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func ListPostLikesHandler(w http.ResponseWriter, r *http.Request) {
+	postID, ok := pathUUID(w, r, "id")
+	if !ok {
+		return
+	}
+	offset, limit, err := parsePagination(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	users, err := services.ListPostLikes(r.Context(), auth.UserIDFromContext(r.Context()), postID, offset, limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, users)
+}
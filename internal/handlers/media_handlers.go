@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http"
+	"social/internal/auth"
+	"social/internal/errors"
+	"social/internal/services"
+	"social/internal/validation"
+)
+
+// maxMultipartOverhead leaves room for part headers and boundaries on top of the file itself
+const maxMultipartOverhead = 1 << 20
+
+// UploadMediaHandler accepts a multipart/form-data body with the file in the "file" part
+func UploadMediaHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, services.MaxMediaBytes+maxMultipartOverhead)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, r, validation.Errors{{Field: "body", Message: "must be multipart/form-data"}})
+		return
+	}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			writeError(w, r, validation.Errors{{Field: "file", Message: "is required"}})
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		attachment, err := services.UploadMedia(r.Context(), auth.UserIDFromContext(r.Context()), part)
+		part.Close()
+		if err != nil {
+			if _, ok := err.(*http.MaxBytesError); ok {
+				err = errors.ErrMediaTooLarge
+			}
+			writeError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, attachment)
+		return
+	}
+}
+
+// Cache lifetimes of served files; a file stops being served once its post is deleted. Files fetched
+// with a token may be visible to that viewer only, so shared caches must not keep them.
+const (
+	mediaCacheControl        = "public, max-age=3600"
+	privateMediaCacheControl = "private, max-age=3600"
+)
+
+// ServeMediaHandler serves an uploaded file to whoever may see it. Range requests and conditional requests
+// are handled by http.ServeContent. Files never change, but they go away with their post, so they are cached
+// only for a while and revalidated by ETag.
+func ServeMediaHandler(w http.ResponseWriter, r *http.Request) {
+	mediaID, ok := pathUUID(w, r, "id")
+	if !ok {
+		return
+	}
+	viewerID := auth.UserIDFromContext(r.Context())
+	attachment, obj, err := services.OpenMedia(r.Context(), viewerID, mediaID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer obj.Close()
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	if viewerID == "" {
+		w.Header().Set("Cache-Control", mediaCacheControl)
+	} else {
+		w.Header().Set("Cache-Control", privateMediaCacheControl)
+	}
+	w.Header().Set("ETag", `"`+attachment.ID+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", obj.ModTime(), obj)
+}
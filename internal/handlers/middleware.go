@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"social/internal/auth"
+	"social/internal/errors"
+	"social/internal/services"
+	"strings"
+)
+
+// RequireAuth resolves the caller from a bearer token and stores the user ID in the request context.
+// The token may also be passed as the "token" query parameter, since browsers cannot set headers on WebSocket upgrades.
+func RequireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			writeError(w, r, errors.ErrUnauthorized)
+			return
+		}
+		claims, err := services.Authenticate(r.Context(), token)
+		if err != nil {
+			log.Printf("RequireAuth: rejected token for %s: %v", r.URL.Path, err)
+			writeError(w, r, err)
+			return
+		}
+		next(w, r.WithContext(auth.WithSession(r.Context(), claims.UserID, claims.SessionID)))
+	}
+}
+
+// OptionalAuth resolves the caller like RequireAuth when a token is present, and lets anonymous requests through.
+// An invalid token is still rejected so that clients notice expired credentials.
+func OptionalAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if bearerToken(r) == "" {
+			next(w, r)
+			return
+		}
+		RequireAuth(next)(w, r)
+	}
+}
+
+// Subresources dispatches on the {resource} path wildcard. It serves patterns like "GET /post/{id}/likes"
+// that ServeMux rejects as conflicting with "GET /post/get/{id}".
+func Subresources(routes map[string]http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler, ok := routes[r.PathValue("resource")]
+		if !ok {
+			writeError(w, r, errors.ErrNotFound)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func bearerToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		if token, ok := strings.CutPrefix(h, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	return r.URL.Query().Get("token")
+}
+
+// clientIP returns the caller address, preferring the first hop of X-Forwarded-For set by the proxy
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		ip, _, _ := strings.Cut(fwd, ",")
+		return strings.TrimSpace(ip)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
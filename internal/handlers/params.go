@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"net/http"
+	"social/internal/pagination"
+	"social/internal/validation"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageLimit = 10
+	maxPageLimit     = 100
+)
+
+// parsePagination reads offset and limit query parameters, rejecting malformed values and capping the limit
+func parsePagination(r *http.Request) (offset, limit int, err error) {
+	offset, limit = 0, defaultPageLimit
+	var v validation.Validator
+	if val := r.URL.Query().Get("offset"); val != "" {
+		if offset, err = strconv.Atoi(val); err != nil || offset < 0 {
+			v.Add("offset", "must be a non-negative integer")
+		}
+	}
+	if val := r.URL.Query().Get("limit"); val != "" {
+		if limit, err = strconv.Atoi(val); err != nil || limit <= 0 {
+			v.Add("limit", "must be a positive integer")
+		}
+	}
+	if err := v.Err(); err != nil {
+		return 0, 0, err
+	}
+	return offset, min(limit, maxPageLimit), nil
+}
+
+// parseCursor reads the optional cursor query parameter; nil means the first page.
+// The cursor must carry a UUID, as the lists it pages through are keyed by UUIDs.
+func parseCursor(r *http.Request) (*pagination.Cursor, error) {
+	cursor, err := parseCursorParam(r, "cursor")
+	if err == nil && cursor != nil {
+		if _, uuidErr := uuid.Parse(cursor.ID); uuidErr != nil {
+			err = validation.Errors{{Field: "cursor", Message: "is malformed"}}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
+// parseCursorParam reads an optional cursor from the named query parameter
+func parseCursorParam(r *http.Request, name string) (*pagination.Cursor, error) {
+	val := r.URL.Query().Get(name)
+	if val == "" {
+		return nil, nil
+	}
+	cursor, err := pagination.Decode(val)
+	if err != nil {
+		return nil, validation.Errors{{Field: name, Message: "is malformed"}}
+	}
+	return &cursor, nil
+}
+
+// parseMessageCursors reads the before and after cursors of a dialog page. Message cursors carry
+// numeric message IDs, and at most one direction may be given.
+func parseMessageCursors(r *http.Request) (before, after *pagination.Cursor, err error) {
+	var v validation.Validator
+	parse := func(name string) *pagination.Cursor {
+		cursor, err := parseCursorParam(r, name)
+		if err == nil && cursor != nil {
+			_, err = strconv.ParseInt(cursor.ID, 10, 64)
+		}
+		if err != nil {
+			v.Add(name, "is malformed")
+			return nil
+		}
+		return cursor
+	}
+	before, after = parse("before"), parse("after")
+	if before != nil && after != nil {
+		v.Add("after", "cannot be combined with before")
+	}
+	if err := v.Err(); err != nil {
+		return nil, nil, err
+	}
+	return before, after, nil
+}
+
+const maxBodyBytes = 1 << 20
+
+// readJSON decodes the request body into dst and runs check on it.
+// On failure it answers 400 with field-level errors and returns false.
+func readJSON(w http.ResponseWriter, r *http.Request, dst any, check func() error) bool {
+	err := validation.DecodeJSON(http.MaxBytesReader(w, r.Body, maxBodyBytes), dst)
+	if err == nil && check != nil {
+		err = check()
+	}
+	if err == nil {
+		return true
+	}
+	writeError(w, r, err)
+	return false
+}
+
+// pathUUID reads a UUID path parameter, answering 400 with a field error when it is malformed
+func pathUUID(w http.ResponseWriter, r *http.Request, name string) (string, bool) {
+	value := r.PathValue(name)
+	var v validation.Validator
+	v.UUID(name, value)
+	if err := v.Err(); err != nil {
+		writeError(w, r, err)
+		return "", false
+	}
+	return value, true
+}
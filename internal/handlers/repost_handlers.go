@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+	"social/internal/auth"
+	"social/internal/services"
+	"social/internal/validation"
+)
+
+func RepostHandler(w http.ResponseWriter, r *http.Request) {
+	postID, ok := pathUUID(w, r, "id")
+	if !ok {
+		return
+	}
+	// The comment is optional, so is the body
+	var payload struct {
+		Text string `json:"text"`
+	}
+	if r.ContentLength != 0 && !readJSON(w, r, &payload, func() error {
+		var v validation.Validator
+		v.MaxLength("text", payload.Text, validation.MaxPostLength)
+		return v.Err()
+	}) {
+		return
+	}
+
+	post, err := services.CreateRepost(r.Context(), auth.UserIDFromContext(r.Context()), postID, payload.Text)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, post)
+}
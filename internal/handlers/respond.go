@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"social/internal/errors"
+	"social/internal/validation"
+
+	"github.com/google/uuid"
+)
+
+type requestIDKey struct{}
+
+// errorBody is the JSON envelope of every error response
+type errorBody struct {
+	Error errorDetails `json:"error"`
+}
+
+type errorDetails struct {
+	Code      string                  `json:"code"`
+	Message   string                  `json:"message"`
+	RequestID string                  `json:"request_id"`
+	Fields    []validation.FieldError `json:"fields,omitempty"`
+}
+
+// WithRequestID assigns every request an ID, echoed in the X-Request-Id header and in error bodies.
+// Requests that match no route get the same JSON error envelope as handler errors.
+func WithRequestID(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-Id")
+		if requestID == "" || len(requestID) > 128 {
+			requestID = uuid.NewString()
+		}
+		w.Header().Set("X-Request-Id", requestID)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, requestID))
+
+		if h, pattern := mux.Handler(r); pattern == "" {
+			// ServeMux answers unmatched routes with plain text; capture the status and the Allow header instead
+			rec := &statusRecorder{header: w.Header()}
+			h.ServeHTTP(rec, r)
+			if rec.status == http.StatusMethodNotAllowed {
+				writeError(w, r, errors.ErrMethodNotAllowed)
+			} else {
+				writeError(w, r, errors.ErrNotFound)
+			}
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// writeError answers with the JSON error envelope. Application errors carry their own code and status,
+// validation errors list the offending fields, and anything else is logged and reported as an opaque 500.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	details := errorDetails{RequestID: requestIDFromContext(r.Context())}
+	status := http.StatusInternalServerError
+
+	if fieldErrs, ok := err.(validation.Errors); ok {
+		status = errors.ErrValidation.Status
+		details.Code = errors.ErrValidation.Code
+		details.Message = errors.ErrValidation.Message
+		details.Fields = fieldErrs
+	} else if appErr, ok := errors.As(err); ok {
+		status = appErr.Status
+		details.Code = appErr.Code
+		details.Message = appErr.Message
+	} else {
+		details.Code = errors.ErrInternal.Code
+		details.Message = errors.ErrInternal.Message
+	}
+	if status >= http.StatusInternalServerError {
+		log.Printf("[%s] %s %s: %v", details.RequestID, r.Method, r.URL.Path, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorBody{Error: details})
+}
+
+// writeJSON answers with v encoded as JSON
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// statusRecorder records the status written by a handler and discards its body
+type statusRecorder struct {
+	header http.Header
+	status int
+}
+
+func (r *statusRecorder) Header() http.Header         { return r.header }
+func (r *statusRecorder) Write(b []byte) (int, error) { return len(b), nil }
+func (r *statusRecorder) WriteHeader(status int)      { r.status = status }
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"social/internal/auth"
+	"social/internal/errors"
+	"social/internal/services"
+	"social/internal/validation"
+)
+
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	sessionID := auth.SessionIDFromContext(r.Context())
+	if err := services.RevokeSession(r.Context(), userID, sessionID); err != nil && err != errors.ErrSessionNotFound {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	if err := services.RevokeAllSessions(r.Context(), userID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	sessions, err := services.ListSessions(r.Context(), userID, auth.SessionIDFromContext(r.Context()))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+func RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	userID := auth.UserIDFromContext(r.Context())
+	if err := services.RevokeSession(r.Context(), userID, r.PathValue("session_id")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if !readJSON(w, r, &payload, func() error { return validation.Required("refresh_token", payload.RefreshToken) }) {
+		return
+	}
+	tokens, err := services.RefreshSession(r.Context(), payload.RefreshToken)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
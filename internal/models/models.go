@@ -12,21 +12,177 @@ type User struct {
 	Password  string `json:"password"`
 }
 
+// UserView is a profile as seen by an authenticated viewer
+type UserView struct {
+	User
+	FriendshipStatus   string `json:"friendship_status,omitempty"`
+	MutualFriendsCount int    `json:"mutual_friends_count"`
+	MutualFriends      []User `json:"mutual_friends,omitempty"`
+}
+
+const (
+	FriendshipNone       = "none"
+	FriendshipFriend     = "friend"
+	FriendshipPendingIn  = "pending-in"
+	FriendshipPendingOut = "pending-out"
+	FriendshipBlocked    = "blocked"
+)
+
+type FriendSuggestion struct {
+	User
+	MutualFriends int  `json:"mutual_friends"`
+	SameCity      bool `json:"same_city"`
+}
+
+// UserUpdate is a partial profile update; nil fields are left unchanged
+type UserUpdate struct {
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Birthdate *string `json:"birthdate"`
+	Biography *string `json:"biography"`
+	City      *string `json:"city"`
+}
+
+type PasswordChange struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
 type Credentials struct {
 	ID       string `json:"id"`
 	Password string `json:"password"`
 }
 
+// Post is a post or, when RepostOfID is set, a repost whose Text is the reposter's comment.
+// RepostOf holds the original post; RepostUnavailable is set instead when the original was deleted.
 type Post struct {
+	ID                string       `json:"id"`
+	Text              string       `json:"text"`
+	CreatedAt         string       `json:"created_at"`
+	UpdatedAt         string       `json:"updated_at,omitempty"`
+	AuthorUserID      string       `json:"author_user_id"`
+	LikeCount         int          `json:"like_count"`
+	LikedByMe         bool         `json:"liked_by_me"`
+	CommentCount      int          `json:"comment_count"`
+	RepostCount       int          `json:"repost_count"`
+	RepostOfID        string       `json:"repost_of_id,omitempty"`
+	RepostOf          *Post        `json:"repost_of,omitempty"`
+	RepostUnavailable bool         `json:"repost_unavailable,omitempty"`
+	Attachments       []Attachment `json:"attachments,omitempty"`
+}
+
+// Attachment is an uploaded media file; Width and Height are set for images
+type Attachment struct {
+	ID          string `json:"id"`
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+}
+
+// PostPage is a page of a feed; NextCursor is empty on the last page
+type PostPage struct {
+	Posts      []Post `json:"posts"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Comment is a comment on a post; ParentID is set for replies.
+// A deleted comment is kept without its text while it has replies.
+type Comment struct {
 	ID           string `json:"id"`
+	PostID       string `json:"post_id"`
+	ParentID     string `json:"parent_id,omitempty"`
+	AuthorUserID string `json:"author_user_id"`
 	Text         string `json:"text"`
+	ReplyCount   int    `json:"reply_count"`
+	Deleted      bool   `json:"deleted,omitempty"`
 	CreatedAt    string `json:"created_at"`
-	AuthorUserID string `json:"author_user_id"`
+	UpdatedAt    string `json:"updated_at,omitempty"`
+}
+
+// CommentPage is a page of comments; NextCursor is empty on the last page
+type CommentPage struct {
+	Comments   []Comment `json:"comments"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+type FriendRequest struct {
+	ID         string    `json:"id"`
+	FromUserID string    `json:"from_user_id"`
+	ToUserID   string    `json:"to_user_id"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+const (
+	FriendRequestPending   = "pending"
+	FriendRequestAccepted  = "accepted"
+	FriendRequestDeclined  = "declined"
+	FriendRequestCancelled = "cancelled"
+)
+
+type Block struct {
+	UserID    string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 type Message struct {
+	ID         int64     `json:"id"`
 	FromUserID string    `json:"from"`
 	ToUserID   string    `json:"to"`
 	Text       string    `json:"text"`
 	CreatedAt  time.Time `json:"created_at"`
 }
+
+type MessagePage struct {
+	Messages   []Message `json:"messages"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+	PrevCursor string    `json:"prev_cursor,omitempty"`
+}
+
+// Conversation is an entry of a user's chat list
+type Conversation struct {
+	UserID        string    `json:"user_id"`
+	LastMessage   Message   `json:"last_message"`
+	LastMessageAt time.Time `json:"last_message_at"`
+	UnreadCount   int       `json:"unread_count"`
+	// Read marks: the last message read by the user and by the partner
+	LastReadMessageID    int64 `json:"last_read_message_id"`
+	PartnerReadMessageID int64 `json:"partner_read_message_id"`
+}
+
+type ConversationPage struct {
+	Conversations []Conversation `json:"conversations"`
+	NextCursor    string         `json:"next_cursor,omitempty"`
+}
+
+// Counters are the unread totals shown next to the messages section
+type Counters struct {
+	UnreadMessages int `json:"unread_messages"`
+	UnreadDialogs  int `json:"unread_dialogs"`
+}
+
+// Presence is whether a user is online and when they were last seen
+type Presence struct {
+	UserID   string     `json:"user_id"`
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
+
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Current   bool      `json:"current"`
+}
+
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
@@ -1,6 +1,7 @@
 package rabbit
 
 import (
+	"encoding/json"
 	"log"
 	"os"
 	"time"
@@ -45,3 +46,40 @@ func CloseRabbit() {
 		RabbitConn.Close()
 	}
 }
+
+// PublishJSON publishes v as a persistent JSON message to the named queue via the default exchange
+func PublishJSON(queueName string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return RabbitChan.Publish(
+		"",        // default exchange
+		queueName, // routing key = имя очереди
+		false, false,
+		amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Body:         body,
+		},
+	)
+}
+
+// PublishEvent publishes v as a transient JSON message to an exchange. Real-time events are
+// useless once stale, so they are not persisted.
+func PublishEvent(exchange, routingKey string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return RabbitChan.Publish(
+		exchange,
+		routingKey,
+		false, false,
+		amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Transient,
+			Body:         body,
+		},
+	)
+}
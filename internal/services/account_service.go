@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"social/internal/db"
+	"social/internal/errors"
+	"social/internal/models"
+	"social/internal/utils"
+	"strconv"
+	"strings"
+)
+
+// UpdateUser applies a partial profile update and returns the updated profile
+func UpdateUser(ctx context.Context, userID string, update *models.UserUpdate) (*models.User, error) {
+	var sets []string
+	var args []any
+	addSet := func(column string, value *string) {
+		if value != nil {
+			args = append(args, *value)
+			sets = append(sets, column+" = $"+strconv.Itoa(len(args)))
+		}
+	}
+	addSet("first_name", update.FirstName)
+	addSet("last_name", update.LastName)
+	addSet("birthdate", update.Birthdate)
+	addSet("biography", update.Biography)
+	addSet("city", update.City)
+	if len(sets) == 0 {
+		return GetUserByID(userID)
+	}
+
+	args = append(args, userID)
+	query := "UPDATE users SET " + strings.Join(sets, ", ") + " WHERE id = $" + strconv.Itoa(len(args)) +
+		" RETURNING id, first_name, last_name, birthdate, biography, city"
+	var user models.User
+	err := db.WriteDB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID, &user.FirstName, &user.LastName, &user.Birthdate, &user.Biography, &user.City)
+	if err == sql.ErrNoRows {
+		return nil, errors.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if update.City != nil {
+		// Shared city is part of suggestion ranking
+		scheduleSuggestionsRefresh(ctx, userID)
+	}
+	return &user, nil
+}
+
+// ChangePassword replaces the user's password after checking the old one and
+// revokes every session except the one the change was made from
+func ChangePassword(ctx context.Context, userID, currentSessionID string, change *models.PasswordChange) error {
+	var storedPassword string
+	err := db.WriteDB.QueryRowContext(ctx, "SELECT password FROM users WHERE id = $1", userID).Scan(&storedPassword)
+	if err == sql.ErrNoRows {
+		return errors.ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !utils.CheckPasswordHash(change.OldPassword, storedPassword) {
+		return errors.ErrInvalidPassword
+	}
+	hashedPassword, err := utils.HashPassword(change.NewPassword)
+	if err != nil {
+		return err
+	}
+	if _, err := db.WriteDB.ExecContext(ctx, "UPDATE users SET password = $1 WHERE id = $2", hashedPassword, userID); err != nil {
+		return err
+	}
+	return RevokeOtherSessions(ctx, userID, currentSessionID)
+}
+
+// DeleteUser removes the account and its data: posts, friendships, Citus messages and conversations,
+// and Redis feed caches, in that order. The users row goes last so that a failed deletion
+// can simply be retried by the still existing account.
+func DeleteUser(ctx context.Context, userID string) error {
+	// Followers' feeds contain the user's posts, collect them before the friendships are gone
+	followerIDs, err := GetFriendIDs(userID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.WriteDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	likedPostIDs, err := deleteUserLikes(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	commentedPostIDs, err := deleteUserComments(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	repostedPostIDs, err := uncountUserReposts(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	mediaKeys, err := deleteUserMedia(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	cleanup := []string{
+		"DELETE FROM post_likes WHERE post_id IN (SELECT id FROM posts WHERE author_user_id = $1)",
+		"DELETE FROM friends WHERE user_id = $1 OR friend_id = $1",
+		"DELETE FROM friend_requests WHERE from_user_id = $1 OR to_user_id = $1",
+		"DELETE FROM blocks WHERE blocker_id = $1 OR blocked_id = $1",
+	}
+	for _, query := range cleanup {
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			return err
+		}
+	}
+	postIDs, err := deleteUserPosts(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// messages is distributed by the pair shard_key, so this fans out to all shards
+	_, err = db.CitusDB.ExecContext(ctx,
+		"DELETE FROM messages WHERE from_user_id = $1 OR to_user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+	if err := deleteUserConversations(ctx, userID); err != nil {
+		return err
+	}
+
+	for _, postID := range likedPostIDs {
+		changeLikeCount(ctx, postID, -1)
+	}
+	for _, postID := range commentedPostIDs {
+		dropPostCounter(ctx, commentCountKeyPrefix, postID)
+	}
+	for _, postID := range repostedPostIDs {
+		dropPostCounter(ctx, repostCountKeyPrefix, postID)
+	}
+	postKeys := make([]string, len(postIDs))
+	for i, id := range postIDs {
+		postKeys[i] = postKeyPrefix + id
+	}
+	if len(postKeys) > 0 {
+		redisClient.Del(ctx, postKeys...)
+	}
+	deleteMediaFiles(ctx, mediaKeys)
+	invalidateFeedCache(ctx, append(followerIDs, userID)...)
+	redisClient.Del(ctx, suggestionsKeyPrefix+userID)
+	if len(followerIDs) > 0 {
+		scheduleSuggestionsRefresh(ctx, followerIDs...)
+	}
+
+	if err := RevokeAllSessions(ctx, userID); err != nil {
+		log.Printf("DeleteUser: failed to revoke sessions of user %s: %v", userID, err)
+	}
+	_, err = db.WriteDB.ExecContext(ctx, "DELETE FROM users WHERE id = $1", userID)
+	return err
+}
@@ -0,0 +1,250 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"social/internal/auth"
+	"social/internal/errors"
+	"social/internal/models"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	sessionKeyPrefix      = "session:"
+	userSessionsKeyPrefix = "user_sessions:"
+)
+
+// storedSession is the Redis representation of a session. A session is a refresh token family:
+// it lives as long as its current refresh token and is rotated on every refresh. Only the hash of
+// the current token is kept; the session ID in a token names its family, so any other token of a
+// live session is one the session already rotated, however long ago.
+type storedSession struct {
+	models.Session
+	RefreshHash string `json:"refresh_hash"`
+}
+
+// StartSession creates a session for a logged-in user and issues its first token pair
+func StartSession(ctx context.Context, userID, userAgent, ip string) (*models.TokenPair, error) {
+	session := storedSession{
+		Session: models.Session{
+			ID:        uuid.NewString(),
+			UserID:    userID,
+			UserAgent: userAgent,
+			IP:        ip,
+			CreatedAt: time.Now().UTC(),
+		},
+	}
+	return issueTokenPair(ctx, &session)
+}
+
+// RefreshSession trades a refresh token for a new token pair and rotates the refresh token.
+// Presenting any token of a live session other than its current one revokes the whole session
+// as a reused token; tokens of unknown sessions are just rejected.
+func RefreshSession(ctx context.Context, refreshToken string) (*models.TokenPair, error) {
+	sessionID, hash, err := auth.ParseRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	sessionKey := sessionKeyPrefix + sessionID
+
+	var pair *models.TokenPair
+	var reused *storedSession
+	err = redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		sessionJSON, err := tx.Get(ctx, sessionKey).Result()
+		if err == redis.Nil {
+			return errors.ErrInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+		var session storedSession
+		if err := json.Unmarshal([]byte(sessionJSON), &session); err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare([]byte(session.RefreshHash), []byte(hash)) != 1 {
+			reused = &session
+			return errors.ErrRefreshTokenReused
+		}
+		pair, err = issueTokenPairTx(ctx, tx, &session)
+		return err
+	}, sessionKey)
+
+	if err == errors.ErrRefreshTokenReused {
+		log.Printf("RefreshSession: refresh token reuse detected for session %s, revoking", sessionID)
+		if revokeErr := RevokeSession(ctx, reused.UserID, sessionID); revokeErr != nil {
+			log.Printf("RefreshSession: failed to revoke session %s: %v", sessionID, revokeErr)
+		}
+		return nil, err
+	}
+	if err == redis.TxFailedErr {
+		// A concurrent refresh with the same token won the race
+		return nil, errors.ErrRefreshTokenReused
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+func issueTokenPair(ctx context.Context, session *storedSession) (*models.TokenPair, error) {
+	var pair *models.TokenPair
+	err := redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		var err error
+		pair, err = issueTokenPairTx(ctx, tx, session)
+		return err
+	})
+	return pair, err
+}
+
+// issueTokenPairTx rotates the session's refresh token, extends its lifetime and signs a new access token
+func issueTokenPairTx(ctx context.Context, tx *redis.Tx, session *storedSession) (*models.TokenPair, error) {
+	refreshToken, refreshHash, err := auth.NewRefreshToken(session.ID)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, _, err := auth.IssueToken(session.UserID, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	session.RefreshHash = refreshHash
+	session.ExpiresAt = time.Now().Add(auth.RefreshTokenTTL()).UTC()
+	sessionJSON, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+	userKey := userSessionsKeyPrefix + session.UserID
+
+	_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, sessionKeyPrefix+session.ID, sessionJSON, auth.RefreshTokenTTL())
+		pipe.SAdd(ctx, userKey, session.ID)
+		pipe.Expire(ctx, userKey, auth.RefreshTokenTTL())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &models.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(auth.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+// Authenticate resolves the user and session behind an access token.
+// A token is accepted only while its session has not been revoked.
+func Authenticate(ctx context.Context, token string) (*auth.Claims, error) {
+	claims, err := auth.ParseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	exists, err := redisClient.Exists(ctx, sessionKeyPrefix+claims.SessionID).Result()
+	if err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, errors.ErrSessionRevoked
+	}
+	return claims, nil
+}
+
+// ListSessions returns the active sessions of a user, newest first
+func ListSessions(ctx context.Context, userID, currentSessionID string) ([]models.Session, error) {
+	userKey := userSessionsKeyPrefix + userID
+	ids, err := redisClient.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	sessions := []models.Session{}
+	if len(ids) == 0 {
+		return sessions, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = sessionKeyPrefix + id
+	}
+	values, err := redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		sessionJSON, ok := value.(string)
+		if !ok {
+			// Session expired, drop it from the index
+			redisClient.SRem(ctx, userKey, ids[i])
+			continue
+		}
+		var session models.Session
+		if err := json.Unmarshal([]byte(sessionJSON), &session); err != nil {
+			continue
+		}
+		session.Current = session.ID == currentSessionID
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+// RevokeSession invalidates a single session of the user and closes its WebSocket connections
+func RevokeSession(ctx context.Context, userID, sessionID string) error {
+	userKey := userSessionsKeyPrefix + userID
+	isMember, err := redisClient.SIsMember(ctx, userKey, sessionID).Result()
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		return errors.ErrSessionNotFound
+	}
+	pipe := redisClient.TxPipeline()
+	pipe.Del(ctx, sessionKeyPrefix+sessionID)
+	pipe.SRem(ctx, userKey, sessionID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	closeUserSockets(userID, sessionID)
+	return nil
+}
+
+// RevokeAllSessions invalidates every session of the user and closes all of their WebSocket connections
+func RevokeAllSessions(ctx context.Context, userID string) error {
+	userKey := userSessionsKeyPrefix + userID
+	ids, err := redisClient.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return err
+	}
+	pipe := redisClient.TxPipeline()
+	for _, id := range ids {
+		pipe.Del(ctx, sessionKeyPrefix+id)
+	}
+	pipe.Del(ctx, userKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	closeUserSockets(userID, "")
+	return nil
+}
+
+// RevokeOtherSessions invalidates every session of the user except keepSessionID
+func RevokeOtherSessions(ctx context.Context, userID, keepSessionID string) error {
+	ids, err := redisClient.SMembers(ctx, userSessionsKeyPrefix+userID).Result()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id == keepSessionID {
+			continue
+		}
+		if err := RevokeSession(ctx, userID, id); err != nil && err != errors.ErrSessionNotFound {
+			return err
+		}
+	}
+	return nil
+}
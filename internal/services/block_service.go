@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"log"
+	"social/internal/db"
+	"social/internal/errors"
+	"social/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// BlockUser blocks blockedID for the user. The block also removes any friendship
+// between the two and cancels pending friend requests in both directions.
+func BlockUser(ctx context.Context, userID, blockedID string) error {
+	if userID == blockedID {
+		return errors.ErrCannotBlockSelf
+	}
+	tx, err := db.WriteDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO blocks (blocker_id, blocked_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		userID, blockedID,
+	)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" { // foreign_key_violation
+			return errors.ErrUserNotFound
+		}
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM friends
+		WHERE (user_id = $1 AND friend_id = $2) OR (user_id = $2 AND friend_id = $1)
+	`, userID, blockedID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE friend_requests SET status = 'cancelled', updated_at = CURRENT_TIMESTAMP
+		WHERE status = 'pending' AND
+		((from_user_id = $1 AND to_user_id = $2) OR (from_user_id = $2 AND to_user_id = $1))
+	`, userID, blockedID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	invalidateFeedCache(ctx, userID, blockedID)
+	scheduleSuggestionsRefresh(ctx, userID, blockedID)
+	return nil
+}
+
+// UnblockUser removes a block created by the user
+func UnblockUser(ctx context.Context, userID, blockedID string) error {
+	res, err := db.WriteDB.ExecContext(ctx,
+		"DELETE FROM blocks WHERE blocker_id = $1 AND blocked_id = $2",
+		userID, blockedID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errors.ErrBlockNotFound
+	}
+	return nil
+}
+
+// ListBlockedUsers returns the users blocked by the user, most recent first
+func ListBlockedUsers(ctx context.Context, userID string, offset, limit int) ([]models.Block, error) {
+	rows, err := db.ReadDB.QueryContext(ctx, `
+		SELECT blocked_id, created_at FROM blocks
+		WHERE blocker_id = $1
+		ORDER BY created_at DESC
+		OFFSET $2 LIMIT $3
+	`, userID, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocks := []models.Block{}
+	for rows.Next() {
+		var block models.Block
+		if err := rows.Scan(&block.UserID, &block.CreatedAt); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// IsBlockedBetween reports whether either user has blocked the other
+func IsBlockedBetween(ctx context.Context, userID1, userID2 string) (bool, error) {
+	var blocked bool
+	err := db.ReadDB.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM blocks
+			WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+		)`, userID1, userID2).Scan(&blocked)
+	return blocked, err
+}
+
+// blockedPeers returns the set of users that the user blocked or was blocked by
+func blockedPeers(ctx context.Context, userID string) (map[string]struct{}, error) {
+	rows, err := db.ReadDB.QueryContext(ctx, `
+		SELECT blocked_id FROM blocks WHERE blocker_id = $1
+		UNION
+		SELECT blocker_id FROM blocks WHERE blocked_id = $1
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	peers := make(map[string]struct{})
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		peers[id] = struct{}{}
+	}
+	return peers, rows.Err()
+}
+
+// ExcludeBlocked filters out recipients that are blocked by or have blocked the author.
+// On lookup failure the recipients are returned unchanged so that delivery is not lost.
+func ExcludeBlocked(authorID string, recipientIDs []string) []string {
+	peers, err := blockedPeers(context.Background(), authorID)
+	if err != nil {
+		log.Printf("ExcludeBlocked: failed to load blocks of user %s: %v", authorID, err)
+		return recipientIDs
+	}
+	if len(peers) == 0 {
+		return recipientIDs
+	}
+	filtered := make([]string, 0, len(recipientIDs))
+	for _, id := range recipientIDs {
+		if _, blocked := peers[id]; !blocked {
+			filtered = append(filtered, id)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,331 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"social/internal/db"
+	"social/internal/errors"
+	"social/internal/models"
+	"social/internal/pagination"
+	"social/internal/ws"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const commentCountKeyPrefix = "post_comments:" // Comment counter of a post, cached from posts.comment_count
+
+const commentColumns = "comments.id, comments.post_id, comments.parent_id, comments.author_user_id, comments.text, " +
+	"comments.reply_count, comments.created_at, comments.updated_at, comments.deleted_at"
+
+// CreateComment adds a comment to a post the user can see, optionally as a reply to parentID,
+// and notifies the post author and the participants of the thread
+func CreateComment(ctx context.Context, userID, postID, parentID, text string) (*models.Comment, error) {
+	post, err := visiblePost(ctx, userID, postID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.WriteDB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if parentID != "" {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE comments SET reply_count = reply_count + 1
+			WHERE id = $1 AND post_id = $2 AND deleted_at IS NULL
+		`, parentID, postID)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return nil, err
+		} else if n == 0 {
+			return nil, errors.ErrCommentNotFound
+		}
+	}
+	rows, err := tx.QueryContext(ctx, `
+		INSERT INTO comments (post_id, parent_id, author_user_id, text)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+commentColumns,
+		postID, sql.NullString{String: parentID, Valid: parentID != ""}, userID, text,
+	)
+	if err != nil {
+		return nil, err
+	}
+	comments, err := scanComments(rows)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE posts SET comment_count = comment_count + 1 WHERE id = $1", postID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	comment := comments[0]
+	dropPostCounter(ctx, commentCountKeyPrefix, postID)
+
+	go notifyCommentThread(post.AuthorUserID, comment)
+	return &comment, nil
+}
+
+// UpdateComment replaces the text of the user's comment
+func UpdateComment(ctx context.Context, userID, commentID, text string) (*models.Comment, error) {
+	rows, err := db.WriteDB.QueryContext(ctx, `
+		UPDATE comments SET text = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND author_user_id = $3 AND deleted_at IS NULL
+		RETURNING `+commentColumns,
+		text, commentID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	comments, err := scanComments(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(comments) == 0 {
+		return nil, commentOwnershipError(ctx, commentID)
+	}
+	return &comments[0], nil
+}
+
+// DeleteComment soft-deletes a comment. Both the comment author and the post author may delete it.
+// Replies stay in place; a deleted comment with replies is listed without its text.
+func DeleteComment(ctx context.Context, userID, commentID string) error {
+	tx, err := db.WriteDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var postID string
+	var parentID sql.NullString
+	err = tx.QueryRowContext(ctx, `
+		UPDATE comments SET deleted_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND deleted_at IS NULL
+		AND (author_user_id = $2 OR post_id IN (SELECT id FROM posts WHERE author_user_id = $2))
+		RETURNING post_id, parent_id
+	`, commentID, userID).Scan(&postID, &parentID)
+	if err == sql.ErrNoRows {
+		return commentOwnershipError(ctx, commentID)
+	}
+	if err != nil {
+		return err
+	}
+	if parentID.Valid {
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE comments SET reply_count = reply_count - 1 WHERE id = $1", parentID.String); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE posts SET comment_count = comment_count - 1 WHERE id = $1", postID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	dropPostCounter(ctx, commentCountKeyPrefix, postID)
+	return nil
+}
+
+// ListComments returns the page of comments under parentID (top-level comments when empty) that follows
+// the cursor, oldest first. Comments of users blocked in either direction are hidden.
+func ListComments(ctx context.Context, viewerID, postID, parentID string, after *pagination.Cursor, limit int) (models.CommentPage, error) {
+	if _, err := visiblePost(ctx, viewerID, postID); err != nil {
+		return models.CommentPage{}, err
+	}
+	query := `
+		SELECT ` + commentColumns + `
+		FROM comments
+		WHERE comments.post_id = $1
+		AND comments.parent_id IS NOT DISTINCT FROM $2::uuid
+		AND (comments.deleted_at IS NULL OR comments.reply_count > 0)
+		AND NOT EXISTS (
+			SELECT 1 FROM blocks
+			WHERE (blocks.blocker_id = $3 AND blocks.blocked_id = comments.author_user_id)
+			OR (blocks.blocker_id = comments.author_user_id AND blocks.blocked_id = $3)
+		)`
+	args := []any{postID, sql.NullString{String: parentID, Valid: parentID != ""}, viewerID}
+	if after != nil {
+		query += " AND (comments.created_at, comments.id) > ($4::timestamp, $5::uuid)"
+		args = append(args, after.CreatedAt, after.ID)
+	}
+	query += " ORDER BY comments.created_at, comments.id LIMIT $" + strconv.Itoa(len(args)+1)
+	args = append(args, limit)
+
+	rows, err := db.ReadDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return models.CommentPage{}, err
+	}
+	comments, err := scanComments(rows)
+	if err != nil {
+		return models.CommentPage{}, err
+	}
+
+	page := models.CommentPage{Comments: comments}
+	if len(comments) == limit && limit > 0 {
+		last := comments[len(comments)-1]
+		if createdAt, err := time.Parse(time.RFC3339Nano, last.CreatedAt); err == nil {
+			page.NextCursor = pagination.Cursor{CreatedAt: createdAt, ID: last.ID}.Encode()
+		}
+	}
+	return page, nil
+}
+
+// scanComments reads comment rows selected with commentColumns, blanking deleted comments
+func scanComments(rows *sql.Rows) ([]models.Comment, error) {
+	defer rows.Close()
+	comments := []models.Comment{}
+	for rows.Next() {
+		var comment models.Comment
+		var parentID sql.NullString
+		var createdAt time.Time
+		var updatedAt, deletedAt sql.NullTime
+		err := rows.Scan(&comment.ID, &comment.PostID, &parentID, &comment.AuthorUserID, &comment.Text,
+			&comment.ReplyCount, &createdAt, &updatedAt, &deletedAt)
+		if err != nil {
+			return nil, err
+		}
+		comment.ParentID = parentID.String
+		comment.CreatedAt = createdAt.Format(time.RFC3339Nano)
+		comment.UpdatedAt = formatNullTime(updatedAt)
+		if deletedAt.Valid {
+			comment.Text = ""
+			comment.Deleted = true
+		}
+		comments = append(comments, comment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// notifyCommentThread delivers a new comment to the post author and everyone who commented in its thread
+func notifyCommentThread(postAuthorID string, comment models.Comment) {
+	recipients := map[string]struct{}{postAuthorID: {}}
+	if comment.ParentID != "" {
+		participants, err := threadParticipants(context.Background(), comment.ParentID)
+		if err != nil {
+			log.Printf("notifyCommentThread: failed to load participants of comment %s: %v", comment.ID, err)
+		}
+		for _, id := range participants {
+			recipients[id] = struct{}{}
+		}
+	}
+	delete(recipients, comment.AuthorUserID)
+
+	userIDs := make([]string, 0, len(recipients))
+	for id := range recipients {
+		userIDs = append(userIDs, id)
+	}
+	event := ws.CommentPostedMessage{
+		Event:        ws.EventCommentCreated,
+		CommentID:    comment.ID,
+		PostID:       comment.PostID,
+		ParentID:     comment.ParentID,
+		AuthorUserID: comment.AuthorUserID,
+		Text:         comment.Text,
+	}
+	for _, id := range ExcludeBlocked(comment.AuthorUserID, userIDs) {
+		publishUserEvent(id, "", event)
+	}
+}
+
+// threadParticipants returns the authors of all live comments in the thread that contains commentID,
+// where a thread is a top-level comment together with all its replies
+func threadParticipants(ctx context.Context, commentID string) ([]string, error) {
+	rows, err := db.ReadDB.QueryContext(ctx, `
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id FROM comments WHERE id = $1
+			UNION ALL
+			SELECT comments.id, comments.parent_id FROM comments JOIN ancestors ON comments.id = ancestors.parent_id
+		), thread AS (
+			SELECT id, author_user_id, deleted_at FROM comments
+			WHERE id = (SELECT id FROM ancestors WHERE parent_id IS NULL)
+			UNION ALL
+			SELECT comments.id, comments.author_user_id, comments.deleted_at
+			FROM comments JOIN thread ON comments.parent_id = thread.id
+		)
+		SELECT DISTINCT author_user_id FROM thread WHERE deleted_at IS NULL
+	`, commentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// deleteUserComments hard-deletes the user's comments together with their replies and recounts the
+// affected posts. It returns the IDs of those posts.
+func deleteUserComments(ctx context.Context, tx *sql.Tx, userID string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, "DELETE FROM comments WHERE author_user_id = $1 RETURNING post_id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	seen := make(map[string]struct{})
+	var postIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			postIDs = append(postIDs, id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(postIDs) == 0 {
+		return nil, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE posts SET comment_count = (
+			SELECT COUNT(*) FROM comments WHERE comments.post_id = posts.id AND comments.deleted_at IS NULL
+		) WHERE id = ANY($1)
+	`, pq.Array(postIDs))
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE comments SET reply_count = (
+			SELECT COUNT(*) FROM comments replies WHERE replies.parent_id = comments.id AND replies.deleted_at IS NULL
+		) WHERE post_id = ANY($1)
+	`, pq.Array(postIDs))
+	return postIDs, err
+}
+
+// commentOwnershipError tells a missing comment apart from someone else's comment after a guarded write matched nothing
+func commentOwnershipError(ctx context.Context, commentID string) error {
+	var exists bool
+	err := db.WriteDB.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM comments WHERE id = $1 AND deleted_at IS NULL)", commentID,
+	).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return errors.ErrNotCommentAuthor
+	}
+	return errors.ErrCommentNotFound
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"social/internal/db"
+	"social/internal/models"
+	"social/internal/pagination"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+// newerMessage compares the message being indexed with the stored last message of a conversation
+const newerMessage = "(EXCLUDED.last_message_at, EXCLUDED.last_message_id) > (conversations.last_message_at, conversations.last_message_id)"
+
+// indexConversation records a message in the user's conversation list. conversations is distributed
+// by user_id, so each user's list is read from a single shard. A late write of an older message does
+// not replace the last message.
+func indexConversation(ctx context.Context, tx *sql.Tx, userID, partnerID string, message models.Message, unread int) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO conversations (user_id, partner_id, last_message_id, last_from_user_id, last_text, last_message_at, unread_count)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (user_id, partner_id) DO UPDATE SET
+			unread_count = conversations.unread_count + EXCLUDED.unread_count,
+			last_message_id = CASE WHEN `+newerMessage+` THEN EXCLUDED.last_message_id ELSE conversations.last_message_id END,
+			last_from_user_id = CASE WHEN `+newerMessage+` THEN EXCLUDED.last_from_user_id ELSE conversations.last_from_user_id END,
+			last_text = CASE WHEN `+newerMessage+` THEN EXCLUDED.last_text ELSE conversations.last_text END,
+			last_message_at = CASE WHEN `+newerMessage+` THEN EXCLUDED.last_message_at ELSE conversations.last_message_at END
+	`, userID, partnerID, message.ID, message.FromUserID, message.Text, message.CreatedAt, unread)
+	return err
+}
+
+// ListConversations returns the page of the user's conversations that follows the cursor, most
+// recently active first. Conversations with users blocked in either direction are hidden.
+func ListConversations(ctx context.Context, userID string, after *pagination.Cursor, limit int) (models.ConversationPage, error) {
+	blocked, err := blockedPeers(ctx, userID)
+	if err != nil {
+		return models.ConversationPage{}, err
+	}
+	hidden := make([]string, 0, len(blocked))
+	for id := range blocked {
+		hidden = append(hidden, id)
+	}
+
+	query := `
+		SELECT partner_id, last_message_id, last_from_user_id, last_text, last_message_at, unread_count,
+			last_read_message_id, partner_read_message_id
+		FROM conversations
+		WHERE user_id = $1 AND NOT (partner_id = ANY($2::uuid[]))`
+	args := []any{userID, pq.Array(hidden)}
+	if after != nil {
+		query += " AND (last_message_at, partner_id) < ($3::timestamp, $4::uuid)"
+		args = append(args, after.CreatedAt, after.ID)
+	}
+	query += " ORDER BY last_message_at DESC, partner_id DESC LIMIT $" + strconv.Itoa(len(args)+1)
+	args = append(args, limit)
+
+	rows, err := db.CitusDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return models.ConversationPage{}, err
+	}
+	defer rows.Close()
+
+	conversations := []models.Conversation{}
+	for rows.Next() {
+		var c models.Conversation
+		err := rows.Scan(&c.UserID, &c.LastMessage.ID, &c.LastMessage.FromUserID, &c.LastMessage.Text,
+			&c.LastMessage.CreatedAt, &c.UnreadCount, &c.LastReadMessageID, &c.PartnerReadMessageID)
+		if err != nil {
+			return models.ConversationPage{}, err
+		}
+		c.LastMessage.ToUserID = userID
+		if c.LastMessage.FromUserID == userID {
+			c.LastMessage.ToUserID = c.UserID
+		}
+		c.LastMessageAt = c.LastMessage.CreatedAt
+		conversations = append(conversations, c)
+	}
+	if err := rows.Err(); err != nil {
+		return models.ConversationPage{}, err
+	}
+
+	page := models.ConversationPage{Conversations: conversations}
+	if len(conversations) == limit && limit > 0 {
+		last := conversations[len(conversations)-1]
+		page.NextCursor = pagination.Cursor{CreatedAt: last.LastMessageAt, ID: last.UserID}.Encode()
+	}
+	return page, nil
+}
+
+// deleteUserConversations removes the user's conversations from both sides and from the partners' unread counters
+func deleteUserConversations(ctx context.Context, userID string) error {
+	// Partner rows are spread over all shards
+	rows, err := db.CitusDB.QueryContext(ctx,
+		"DELETE FROM conversations WHERE user_id = $1 OR partner_id = $1 RETURNING user_id", userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var partnerIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		if id != userID {
+			partnerIDs = append(partnerIDs, id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if _, err := db.CitusDB.ExecContext(ctx, "DELETE FROM unread_outbox WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	pipe := redisClient.Pipeline()
+	ttl := int(counterTTL.Seconds())
+	dropVersioned.Eval(ctx, pipe, unreadKeys(userID), ttl)
+	for _, partnerID := range partnerIDs {
+		dropVersioned.Eval(ctx, pipe, unreadKeys(partnerID), ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("deleteUserConversations: failed to update unread counters: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"social/internal/db"
+	"social/internal/models"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// Unread counters are cached in Redis as a hash per user, partner ID -> unread count, while
+// conversations.unread_count in Citus stays the source of truth. Every change is a two-step saga:
+// the Citus transaction also writes an unread_outbox entry, and once it commits the cached counters
+// are dropped and the entry removed. Entries that outlive unreadOutboxGrace mean the second step
+// failed or the process died; the relay then drops the counters itself. Dropping is idempotent and
+// does not depend on the order changes complete in, so an entry may safely be handled more than once.
+// Dropping also bumps the user's counter version, and a reload only caches what it read from Citus
+// when the version is unchanged, so a load racing with a change cannot cache the old counts.
+const (
+	unreadKeyPrefix        = "unread:"         // Unread counts of a user's conversations
+	unreadVersionKeyPrefix = "unread_version:" // Bumped whenever the user's unread counts change
+	unreadLoadedField      = "_"               // Marks a loaded hash, which may have no unread conversations
+	unreadOutboxInterval   = 5 * time.Second
+	unreadOutboxGrace      = 10 * time.Second
+	unreadOutboxBatch      = 500
+)
+
+// dropVersioned drops a cached value (KEYS[1]) and bumps its version (KEYS[2]), so that a fill which
+// read the source of truth before the change is not cached
+var dropVersioned = redis.NewScript(`
+redis.call("INCR", KEYS[2])
+redis.call("EXPIRE", KEYS[2], ARGV[1])
+redis.call("DEL", KEYS[1])
+return 1
+`)
+
+// fillUnreadCounts caches counts loaded from Citus unless the version changed since the load started
+var fillUnreadCounts = redis.NewScript(`
+if (redis.call("GET", KEYS[2]) or "0") ~= ARGV[1] then
+	return false
+end
+redis.call("DEL", KEYS[1])
+redis.call("HSET", KEYS[1], unpack(ARGV, 3))
+redis.call("EXPIRE", KEYS[1], ARGV[2])
+return 1
+`)
+
+func unreadKeys(userID string) []string {
+	return []string{unreadKeyPrefix + userID, unreadVersionKeyPrefix + userID}
+}
+
+// GetCounters returns the user's unread totals. Conversations with blocked users are not counted.
+func GetCounters(ctx context.Context, userID string) (models.Counters, error) {
+	unread, err := unreadCounts(ctx, userID)
+	if err != nil {
+		return models.Counters{}, err
+	}
+	blocked, err := blockedPeers(ctx, userID)
+	if err != nil {
+		return models.Counters{}, err
+	}
+	var counters models.Counters
+	for partnerID, n := range unread {
+		if _, hidden := blocked[partnerID]; hidden || n <= 0 {
+			continue
+		}
+		counters.UnreadMessages += n
+		counters.UnreadDialogs++
+	}
+	return counters, nil
+}
+
+// unreadCounts returns the unread count of each of the user's conversations with unread messages
+func unreadCounts(ctx context.Context, userID string) (map[string]int, error) {
+	keys := unreadKeys(userID)
+	cached, err := redisClient.HGetAll(ctx, keys[0]).Result()
+	if err == nil && len(cached) > 0 {
+		counts := make(map[string]int, len(cached))
+		for partnerID, value := range cached {
+			if partnerID != unreadLoadedField {
+				counts[partnerID], _ = strconv.Atoi(value)
+			}
+		}
+		return counts, nil
+	}
+
+	// The version is read before Citus, so a change committed after the query started bumps it
+	version, err := redisClient.Get(ctx, keys[1]).Result()
+	if err == redis.Nil {
+		version = "0"
+	} else if err != nil {
+		log.Printf("unreadCounts: failed to read counter version of user %s: %v", userID, err)
+		version = ""
+	}
+
+	rows, err := db.CitusDB.QueryContext(ctx,
+		"SELECT partner_id, unread_count FROM conversations WHERE user_id = $1 AND unread_count > 0", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[string]int)
+	args := []any{version, int(counterTTL.Seconds()), unreadLoadedField, 1}
+	for rows.Next() {
+		var partnerID string
+		var n int
+		if err := rows.Scan(&partnerID, &n); err != nil {
+			return nil, err
+		}
+		counts[partnerID] = n
+		args = append(args, partnerID, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if version != "" {
+		err := fillUnreadCounts.Run(ctx, redisClient, keys, args...).Err()
+		if err != nil && err != redis.Nil {
+			log.Printf("unreadCounts: failed to cache counters of user %s: %v", userID, err)
+		}
+	}
+	return counts, nil
+}
+
+// addUnreadOutbox records a pending counter change in the transaction that makes it and returns the entry ID
+func addUnreadOutbox(ctx context.Context, tx *sql.Tx, userID string) (string, error) {
+	entryID := uuid.NewString()
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO unread_outbox (user_id, id, created_at) VALUES ($1, $2, $3)", userID, entryID, time.Now())
+	return entryID, err
+}
+
+// completeUnreadChange is the second step of the saga: it drops the user's cached counters after a
+// committed change and clears the outbox entry. On failure the entry is left for the relay to compensate.
+func completeUnreadChange(ctx context.Context, userID, entryID string) {
+	err := dropVersioned.Run(ctx, redisClient, unreadKeys(userID), int(counterTTL.Seconds())).Err()
+	if err != nil {
+		log.Printf("completeUnreadChange: failed to drop counters of user %s: %v", userID, err)
+		return
+	}
+	_, err = db.CitusDB.ExecContext(ctx,
+		"DELETE FROM unread_outbox WHERE user_id = $1 AND id = $2", userID, entryID)
+	if err != nil {
+		log.Printf("completeUnreadChange: failed to clear outbox entry of user %s: %v", userID, err)
+	}
+}
+
+// RunUnreadOutboxRelay periodically compensates counter changes that were not completed, until ctx is done
+func RunUnreadOutboxRelay(ctx context.Context) {
+	ticker := time.NewTicker(unreadOutboxInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			relayUnreadOutbox(ctx)
+		}
+	}
+}
+
+func relayUnreadOutbox(ctx context.Context) {
+	// Entries older than the cutoff were committed before the counters are dropped below,
+	// so the reloaded counters include their changes
+	cutoff := time.Now().Add(-unreadOutboxGrace)
+	rows, err := db.CitusDB.QueryContext(ctx,
+		"SELECT DISTINCT user_id FROM unread_outbox WHERE created_at < $1 LIMIT $2", cutoff, unreadOutboxBatch)
+	if err != nil {
+		log.Printf("relayUnreadOutbox: failed to read outbox: %v", err)
+		return
+	}
+	var userIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			log.Printf("relayUnreadOutbox: failed to read outbox: %v", err)
+			return
+		}
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+
+	for _, userID := range userIDs {
+		err := dropVersioned.Run(ctx, redisClient, unreadKeys(userID), int(counterTTL.Seconds())).Err()
+		if err != nil {
+			log.Printf("relayUnreadOutbox: failed to drop counters of user %s: %v", userID, err)
+			continue
+		}
+		_, err = db.CitusDB.ExecContext(ctx,
+			"DELETE FROM unread_outbox WHERE user_id = $1 AND created_at < $2", userID, cutoff)
+		if err != nil {
+			log.Printf("relayUnreadOutbox: failed to clear outbox of user %s: %v", userID, err)
+		}
+	}
+}
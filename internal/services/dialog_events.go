@@ -0,0 +1,125 @@
+package services
+
+import (
+	"encoding/json"
+	"log"
+	"social/internal/models"
+	"social/internal/rabbit"
+	"social/internal/ws"
+)
+
+// Dialog events are routed between app replicas through a direct exchange. Every replica consumes
+// from its own exclusive queue, which is bound with the IDs of the users connected to it, so an
+// event published with a user ID as the routing key reaches exactly the replicas holding that
+// user's sockets.
+const dialogEventsExchange = "dialog_events"
+
+var dialogEventsQueue string
+
+// dialogDelivery is an event addressed to one user's devices, or a control message that closes them.
+// Closing goes through the exchange too, so a revoked session loses its sockets on every replica.
+type dialogDelivery struct {
+	UserID          string          `json:"user_id"`
+	ExceptSessionID string          `json:"except_session_id,omitempty"`
+	Event           json.RawMessage `json:"event,omitempty"`
+	CloseSessionID  string          `json:"close_session_id,omitempty"`
+	CloseAll        bool            `json:"close_all,omitempty"`
+}
+
+// StartDialogEvents declares this replica's queue and delivers incoming events to local sockets
+func StartDialogEvents() error {
+	ch := rabbit.RabbitChan
+	if err := ch.ExchangeDeclare(dialogEventsExchange, "direct", true, false, false, false, nil); err != nil {
+		return err
+	}
+	// Server-named, exclusive and auto-deleted: the queue and its bindings go away with the replica
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		return err
+	}
+	dialogEventsQueue = q.Name
+	msgs, err := ch.Consume(q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for d := range msgs {
+			var delivery dialogDelivery
+			if err := json.Unmarshal(d.Body, &delivery); err != nil {
+				log.Printf("StartDialogEvents: malformed event: %v", err)
+				continue
+			}
+			switch {
+			case delivery.CloseAll:
+				ws.CloseUser(delivery.UserID)
+			case delivery.CloseSessionID != "":
+				ws.CloseSession(delivery.UserID, delivery.CloseSessionID)
+			default:
+				ws.NotifyUserDevices(delivery.UserID, delivery.ExceptSessionID, delivery.Event)
+			}
+		}
+		// The queue and its bindings died with the channel, so this replica can no longer receive
+		// events. Exit and let the supervisor restart it; clients reconnect and are bound again.
+		log.Fatalf("StartDialogEvents: RabbitMQ channel closed, real-time delivery stopped")
+	}()
+	return nil
+}
+
+// SubscribeUserEvents routes the user's events to this replica; it runs when the user's first socket connects
+func SubscribeUserEvents(userID string) {
+	if err := rabbit.RabbitChan.QueueBind(dialogEventsQueue, userID, dialogEventsExchange, false, nil); err != nil {
+		log.Printf("SubscribeUserEvents: failed to bind user %s: %v", userID, err)
+	}
+}
+
+// UnsubscribeUserEvents stops routing the user's events to this replica once their last socket is gone
+func UnsubscribeUserEvents(userID string) {
+	if err := rabbit.RabbitChan.QueueUnbind(dialogEventsQueue, userID, dialogEventsExchange, nil); err != nil {
+		log.Printf("UnsubscribeUserEvents: failed to unbind user %s: %v", userID, err)
+	}
+}
+
+// publishDialogMessage delivers a new message to the recipient's devices and to the sender's
+// devices other than the one it was sent from
+func publishDialogMessage(message models.Message, senderSessionID string) {
+	event := ws.DialogMessage{
+		Event:      ws.EventDialogMessage,
+		MessageID:  message.ID,
+		FromUserID: message.FromUserID,
+		ToUserID:   message.ToUserID,
+		Text:       message.Text,
+		CreatedAt:  message.CreatedAt,
+	}
+	publishUserEvent(message.ToUserID, "", event)
+	if message.FromUserID != message.ToUserID {
+		publishUserEvent(message.FromUserID, senderSessionID, event)
+	}
+}
+
+// publishUserEvent routes an event to the user's sockets on whichever replicas hold them,
+// skipping the sockets of exceptSessionID
+func publishUserEvent(userID, exceptSessionID string, event any) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("publishUserEvent: failed to marshal event for user %s: %v", userID, err)
+		return
+	}
+	publishDelivery(dialogDelivery{UserID: userID, ExceptSessionID: exceptSessionID, Event: body})
+}
+
+// closeUserSockets disconnects the user's sockets of a session, or all of them when sessionID is empty,
+// on every replica. Local sockets are closed right away in case the broker is unavailable.
+func closeUserSockets(userID, sessionID string) {
+	if sessionID == "" {
+		ws.CloseUser(userID)
+	} else {
+		ws.CloseSession(userID, sessionID)
+	}
+	publishDelivery(dialogDelivery{UserID: userID, CloseSessionID: sessionID, CloseAll: sessionID == ""})
+}
+
+func publishDelivery(delivery dialogDelivery) {
+	if err := rabbit.PublishEvent(dialogEventsExchange, delivery.UserID, delivery); err != nil {
+		log.Printf("publishDelivery: failed to publish event to user %s: %v", delivery.UserID, err)
+	}
+}
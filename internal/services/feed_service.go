@@ -0,0 +1,356 @@
+package services
+
+import (
+	"context"
+	"log"
+	"social/internal/db"
+	"social/internal/models"
+	"social/internal/pagination"
+	"social/internal/rabbit"
+	"social/internal/ws"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/lib/pq"
+)
+
+const (
+	FeedShards            = 8
+	feedQueuePrefix       = "feed_shard_"
+	fanoutBatchSize       = 100
+	authorPostsKeyPrefix  = "author_feed:"      // Recent post IDs of a pull-mode author, scored like feeds
+	celebrityAuthorsKey   = "celebrity_authors" // Authors whose posts are merged at read time
+	followerCountPrefix   = "follower_count:"   // Cached follower count of a push-mode author
+	followerCountTTL      = 10 * time.Minute
+	defaultCelebrityLimit = 10000
+)
+
+// celebrityThreshold is the follower count above which an author's posts are pulled at read time
+// instead of being pushed to every follower's feed
+var celebrityThreshold = defaultCelebrityLimit
+
+// SetCelebrityThreshold configures the follower count above which authors switch to pull mode
+func SetCelebrityThreshold(threshold int) {
+	if threshold > 0 {
+		celebrityThreshold = threshold
+	}
+}
+
+// FeedTask is a post event for a batch of followers, delivered through the feed_shard_N queues.
+// Tasks of pull-mode authors only notify the followers' sockets and leave their feeds alone.
+type FeedTask struct {
+	FriendIDs  []string                 `json:"friend_ids"`
+	Post       ws.PostFeedPostedMessage `json:"post"`
+	NotifyOnly bool                     `json:"notify_only,omitempty"`
+}
+
+// FeedQueueName returns the queue of a feed shard
+func FeedQueueName(shard int) string {
+	return feedQueuePrefix + strconv.Itoa(shard)
+}
+
+// PublishPostEvent fans a post event out to the author's followers through the feed shard queues.
+// Posts of pull-mode authors go to the author's recent-posts list instead of the followers' feeds,
+// but the followers' sockets are still notified.
+func PublishPostEvent(post ws.PostFeedPostedMessage) {
+	ctx := context.Background()
+	celebrity, err := isCelebrityAuthor(ctx, post.AuthorUserID)
+	if err != nil {
+		log.Printf("PublishPostEvent: failed to check author %s mode: %v", post.AuthorUserID, err)
+	}
+	if celebrity {
+		updateAuthorPosts(ctx, post)
+	}
+
+	friendIDs, err := GetFriendIDs(post.AuthorUserID)
+	if err != nil {
+		log.Printf("PublishPostEvent: failed to load followers of user %s: %v", post.AuthorUserID, err)
+		return
+	}
+	for i := 0; i < len(friendIDs); i += fanoutBatchSize {
+		end := min(i+fanoutBatchSize, len(friendIDs))
+		// Группируем по шардам
+		shardBatches := make(map[int][]string)
+		for _, fid := range friendIDs[i:end] {
+			shard := int(hashString(fid) % FeedShards)
+			shardBatches[shard] = append(shardBatches[shard], fid)
+		}
+		for shard, shardFriendIDs := range shardBatches {
+			task := FeedTask{FriendIDs: shardFriendIDs, Post: post, NotifyOnly: celebrity}
+			if err := rabbit.PublishJSON(FeedQueueName(shard), task); err != nil {
+				log.Printf("PublishPostEvent: failed to publish %s for post %s: %v", post.Event, post.PostID, err)
+			}
+		}
+	}
+}
+
+// ProcessFeedTask applies a post event to the followers' materialized feeds and pushes it to their websockets
+func ProcessFeedTask(ctx context.Context, task FeedTask) {
+	if !task.NotifyOnly {
+		switch task.Post.Event {
+		case "":
+			pushToFeeds(ctx, task.FriendIDs, task.Post.PostID, task.Post.CreatedAt)
+		case ws.EventPostDeleted:
+			removeFromFeeds(ctx, task.FriendIDs, task.Post.PostID)
+		}
+	}
+	// Updated bodies live in the shared post cache, so feeds need no change for ws.EventPostUpdated
+	ws.NotifyFriendsBatch(task.FriendIDs, task.Post)
+}
+
+// isCelebrityAuthor reports whether the author is in pull mode, promoting authors whose follower count
+// crossed the threshold. Promotion is sticky: followers' feeds no longer hold the author's newer posts,
+// so switching back to push mode would hide them.
+func isCelebrityAuthor(ctx context.Context, authorID string) (bool, error) {
+	member, err := redisClient.SIsMember(ctx, celebrityAuthorsKey, authorID).Result()
+	if err != nil || member {
+		return member, err
+	}
+	followers, err := followerCount(ctx, authorID)
+	if err != nil {
+		return false, err
+	}
+	if followers <= celebrityThreshold {
+		return false, nil
+	}
+	log.Printf("isCelebrityAuthor: author %s has %d followers, switching to pull mode", authorID, followers)
+	return true, redisClient.SAdd(ctx, celebrityAuthorsKey, authorID).Err()
+}
+
+// followerCount returns the author's follower count, cached for followerCountTTL. A slightly stale count
+// only delays the switch to pull mode.
+func followerCount(ctx context.Context, authorID string) (int, error) {
+	key := followerCountPrefix + authorID
+	if n, err := redisClient.Get(ctx, key).Int(); err == nil {
+		return n, nil
+	}
+	var followers int
+	err := db.ReadDB.QueryRowContext(ctx, "SELECT COUNT(*) FROM friends WHERE friend_id = $1", authorID).Scan(&followers)
+	if err != nil {
+		return 0, err
+	}
+	redisClient.Set(ctx, key, followers, followerCountTTL)
+	return followers, nil
+}
+
+// pushToAuthorPosts adds a post to a materialized recent-posts set and trims it to the newest posts.
+// KEYS: author posts. ARGV: post ID, limit, score.
+var pushToAuthorPosts = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[3], ARGV[1])
+redis.call("ZREMRANGEBYRANK", KEYS[1], 0, -tonumber(ARGV[2]) - 1)
+return 1
+`)
+
+// updateAuthorPosts applies a post event to a pull-mode author's recent-posts set
+func updateAuthorPosts(ctx context.Context, post ws.PostFeedPostedMessage) {
+	key := authorPostsKeyPrefix + post.AuthorUserID
+	switch post.Event {
+	case "":
+		err := pushToAuthorPosts.Run(ctx, redisClient, []string{key}, post.PostID, cacheLimit, postScore(post.CreatedAt)).Err()
+		if err != nil {
+			log.Printf("updateAuthorPosts: failed to push post %s: %v", post.PostID, err)
+		}
+	case ws.EventPostDeleted:
+		redisClient.ZRem(ctx, key, post.PostID)
+	}
+}
+
+// followedCelebrities returns the pull-mode authors the user follows
+func followedCelebrities(ctx context.Context, userID string) ([]string, error) {
+	celebrities, err := redisClient.SMembers(ctx, celebrityAuthorsKey).Result()
+	if err != nil || len(celebrities) == 0 {
+		return nil, err
+	}
+	rows, err := db.ReadDB.QueryContext(ctx,
+		"SELECT friend_id FROM friends WHERE user_id = $1 AND friend_id = ANY($2)",
+		userID, pq.Array(celebrities))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var followed []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		followed = append(followed, id)
+	}
+	return followed, rows.Err()
+}
+
+// loadAuthorPosts materializes the recent-posts set of a pull-mode author when it is missing
+func loadAuthorPosts(ctx context.Context, authorID string) error {
+	key := authorPostsKeyPrefix + authorID
+	exists, err := redisClient.Exists(ctx, key).Result()
+	if err != nil || exists > 0 {
+		return err
+	}
+	rows, err := db.ReadDB.QueryContext(ctx, `
+		SELECT id, created_at FROM posts
+		WHERE author_user_id = $1 AND deleted_at IS NULL
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`, authorID, cacheLimit)
+	if err != nil {
+		return err
+	}
+	entries, err := scanEntries(rows)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	tmpKey := key + feedTmpKeySuffix
+	pipe := redisClient.TxPipeline()
+	pipe.Del(ctx, tmpKey)
+	pipe.ZAdd(ctx, tmpKey, entryPointers(entries)...)
+	pipe.Rename(ctx, tmpKey, key)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// authorPostIDs reads the newest post IDs of a pull-mode author
+func authorPostIDs(ctx context.Context, authorID string, start, stop int64) ([]string, error) {
+	if err := loadAuthorPosts(ctx, authorID); err != nil {
+		return nil, err
+	}
+	return redisClient.ZRevRange(ctx, authorPostsKeyPrefix+authorID, start, stop).Result()
+}
+
+// authorEntries reads up to count recent posts of a pull-mode author that follow the cursor
+func authorEntries(ctx context.Context, authorID string, after *pagination.Cursor, count int64) ([]redis.Z, bool, error) {
+	if err := loadAuthorPosts(ctx, authorID); err != nil {
+		return nil, false, err
+	}
+	key := authorPostsKeyPrefix + authorID
+	pipe := redisClient.Pipeline()
+	entries := zrangeAfter(ctx, pipe, key, after, count)
+	size := pipe.ZCard(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, false, err
+	}
+	return entries(), size.Val() >= cacheLimit, nil
+}
+
+func entryPointers(entries []redis.Z) []*redis.Z {
+	pointers := make([]*redis.Z, len(entries))
+	for i := range entries {
+		pointers[i] = &entries[i]
+	}
+	return pointers
+}
+
+// mergeFeed merges the pushed feed with the recent posts of followed pull-mode authors.
+// Every source is read up to offset+limit items, which is enough to place the requested page,
+// and the union is ordered by creation time with duplicates removed.
+func mergeFeed(ctx context.Context, userID string, celebrities []string, offset, limit int) ([]models.Post, error) {
+	stop := int64(min(offset+limit, cacheLimit) - 1)
+	ids, err := feedPostIDs(ctx, userID, 0, stop)
+	if err != nil {
+		return nil, err
+	}
+	for _, authorID := range celebrities {
+		authorIDs, err := authorPostIDs(ctx, authorID, 0, stop)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, authorIDs...)
+	}
+
+	posts, err := loadPosts(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]struct{}, len(posts))
+	merged := posts[:0]
+	for _, post := range posts {
+		if _, dup := seen[post.ID]; !dup {
+			seen[post.ID] = struct{}{}
+			merged = append(merged, post)
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return postNewer(merged[i], merged[j]) })
+
+	if offset >= len(merged) {
+		return []models.Post{}, nil
+	}
+	return merged[offset:min(offset+limit, len(merged))], nil
+}
+
+// postIDSource reads up to count entries of a newest-first post set that follow the cursor.
+// capped reports that the set holds only the newest cacheLimit posts, so older ones may be missing.
+type postIDSource func(after *pagination.Cursor, count int64) (entries []redis.Z, capped bool, err error)
+
+// mergePostsBefore merges the posts older than the cursor from several newest-first sources.
+// complete is false when a source ran out of cached posts before yielding limit posts, so the
+// page has to be read from Postgres instead.
+func mergePostsBefore(ctx context.Context, sources []postIDSource, after *pagination.Cursor, limit int) ([]models.Post, bool, error) {
+	var merged []models.Post
+	for _, source := range sources {
+		posts, complete, err := postsBefore(ctx, source, after, limit)
+		if err != nil || !complete {
+			return nil, complete, err
+		}
+		merged = append(merged, posts...)
+	}
+
+	seen := make(map[string]struct{}, len(merged))
+	page := make([]models.Post, 0, len(merged))
+	for _, post := range merged {
+		if _, dup := seen[post.ID]; !dup {
+			seen[post.ID] = struct{}{}
+			page = append(page, post)
+		}
+	}
+	sort.Slice(page, func(i, j int) bool { return postNewer(page[i], page[j]) })
+	return page[:min(limit, len(page))], true, nil
+}
+
+// postsBefore seeks a source from the cursor and returns up to limit posts, loading only the bodies
+// of the entries read. Entries whose post is gone are skipped by reading on from the last entry.
+func postsBefore(ctx context.Context, source postIDSource, after *pagination.Cursor, limit int) ([]models.Post, bool, error) {
+	posts := make([]models.Post, 0, limit)
+	for {
+		count := int64(limit - len(posts))
+		entries, capped, err := source(after, count)
+		if err != nil {
+			return nil, false, err
+		}
+		loaded, err := loadPosts(ctx, entryIDs(entries))
+		if err != nil {
+			return nil, false, err
+		}
+		posts = append(posts, loaded...)
+		if int64(len(entries)) < count {
+			return posts, !capped, nil
+		}
+		if len(posts) == limit {
+			return posts, true, nil
+		}
+		last := entries[len(entries)-1]
+		after = &pagination.Cursor{CreatedAt: time.UnixMicro(int64(last.Score)).UTC(), ID: last.Member.(string)}
+	}
+}
+
+// postNewer orders posts by creation time descending, then by ID descending, like the feed SQL
+func postNewer(a, b models.Post) bool {
+	ta, errA := time.Parse(time.RFC3339Nano, a.CreatedAt)
+	tb, errB := time.Parse(time.RFC3339Nano, b.CreatedAt)
+	if errA == nil && errB == nil && !ta.Equal(tb) {
+		return ta.After(tb)
+	}
+	return a.ID > b.ID
+}
+
+// hashString - простая хеш-функция для строк (userID)
+func hashString(s string) uint32 {
+	var h uint32 = 2166136261
+	for i := 0; i < len(s); i++ {
+		h = (h * 16777619) ^ uint32(s[i])
+	}
+	return h
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"social/internal/db"
+	"social/internal/errors"
+	"social/internal/models"
+	"social/internal/ws"
+
+	"github.com/lib/pq"
+)
+
+// SendFriendRequest creates a pending request from the user to toUserID and notifies the recipient
+func SendFriendRequest(ctx context.Context, fromUserID, toUserID string) (*models.FriendRequest, error) {
+	if fromUserID == toUserID {
+		return nil, errors.ErrCannotFriendSelf
+	}
+
+	blocked, err := IsBlockedBetween(ctx, fromUserID, toUserID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, errors.ErrUserBlocked
+	}
+
+	// The primary sees a request the other user has just sent; the unique index on the pair
+	// catches the rest of the races
+	var exists bool
+	err = db.WriteDB.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM friend_requests
+			WHERE status = 'pending' AND
+			((from_user_id = $1 AND to_user_id = $2) OR (from_user_id = $2 AND to_user_id = $1))
+		)`, fromUserID, toUserID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, errors.ErrRequestExists
+	}
+	if friends, err := areMutualFriends(ctx, fromUserID, toUserID); err != nil {
+		return nil, err
+	} else if friends {
+		return nil, errors.ErrAlreadyFriends
+	}
+
+	var req models.FriendRequest
+	err = db.WriteDB.QueryRowContext(ctx, `
+		INSERT INTO friend_requests (from_user_id, to_user_id)
+		VALUES ($1, $2)
+		RETURNING id, from_user_id, to_user_id, status, created_at, updated_at
+	`, fromUserID, toUserID).Scan(&req.ID, &req.FromUserID, &req.ToUserID, &req.Status, &req.CreatedAt, &req.UpdatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			switch pqErr.Code {
+			case "23503": // foreign_key_violation
+				return nil, errors.ErrUserNotFound
+			case "23505": // unique_violation
+				return nil, errors.ErrRequestExists
+			}
+		}
+		return nil, err
+	}
+
+	publishUserEvent(toUserID, "", ws.FriendRequestMessage{
+		Event:      ws.EventFriendRequestReceived,
+		RequestID:  req.ID,
+		FromUserID: fromUserID,
+		ToUserID:   toUserID,
+	})
+	return &req, nil
+}
+
+// AcceptFriendRequest makes the sender and the recipient friends of each other.
+// Only the recipient of a pending request may accept it.
+func AcceptFriendRequest(ctx context.Context, userID, requestID string) error {
+	tx, err := db.WriteDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var fromUserID string
+	err = tx.QueryRowContext(ctx, `
+		UPDATE friend_requests SET status = 'accepted', updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND to_user_id = $2 AND status = 'pending'
+		RETURNING from_user_id
+	`, requestID, userID).Scan(&fromUserID)
+	if err == sql.ErrNoRows {
+		return errors.ErrRequestNotFound
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO friends (user_id, friend_id) VALUES ($1, $2), ($2, $1)
+		ON CONFLICT DO NOTHING
+	`, fromUserID, userID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	invalidateFeedCache(ctx, fromUserID, userID)
+	scheduleSuggestionsRefresh(ctx, fromUserID, userID)
+	publishUserEvent(fromUserID, "", ws.FriendRequestMessage{
+		Event:      ws.EventFriendRequestAccepted,
+		RequestID:  requestID,
+		FromUserID: fromUserID,
+		ToUserID:   userID,
+	})
+	return nil
+}
+
+// DeclineFriendRequest rejects a pending request addressed to the user
+func DeclineFriendRequest(ctx context.Context, userID, requestID string) error {
+	return closeFriendRequest(ctx, requestID, "to_user_id", userID, models.FriendRequestDeclined)
+}
+
+// CancelFriendRequest withdraws a pending request sent by the user
+func CancelFriendRequest(ctx context.Context, userID, requestID string) error {
+	return closeFriendRequest(ctx, requestID, "from_user_id", userID, models.FriendRequestCancelled)
+}
+
+func closeFriendRequest(ctx context.Context, requestID, ownerColumn, userID, status string) error {
+	res, err := db.WriteDB.ExecContext(ctx, `
+		UPDATE friend_requests SET status = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND `+ownerColumn+` = $3 AND status = 'pending'
+	`, status, requestID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errors.ErrRequestNotFound
+	}
+	return nil
+}
+
+// ListIncomingFriendRequests returns pending requests addressed to the user, newest first
+func ListIncomingFriendRequests(ctx context.Context, userID string, offset, limit int) ([]models.FriendRequest, error) {
+	return listFriendRequests(ctx, "to_user_id", userID, offset, limit)
+}
+
+// ListOutgoingFriendRequests returns pending requests sent by the user, newest first
+func ListOutgoingFriendRequests(ctx context.Context, userID string, offset, limit int) ([]models.FriendRequest, error) {
+	return listFriendRequests(ctx, "from_user_id", userID, offset, limit)
+}
+
+func listFriendRequests(ctx context.Context, ownerColumn, userID string, offset, limit int) ([]models.FriendRequest, error) {
+	query := `
+		SELECT id, from_user_id, to_user_id, status, created_at, updated_at
+		FROM friend_requests
+		WHERE ` + ownerColumn + ` = $1 AND status = 'pending'
+		ORDER BY created_at DESC
+		OFFSET $2 LIMIT $3
+	`
+	rows, err := db.ReadDB.QueryContext(ctx, query, userID, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []models.FriendRequest{}
+	for rows.Next() {
+		var req models.FriendRequest
+		if err := rows.Scan(&req.ID, &req.FromUserID, &req.ToUserID, &req.Status, &req.CreatedAt, &req.UpdatedAt); err != nil {
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+func areMutualFriends(ctx context.Context, userID1, userID2 string) (bool, error) {
+	var count int
+	err := db.ReadDB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM friends
+		WHERE (user_id = $1 AND friend_id = $2) OR (user_id = $2 AND friend_id = $1)
+	`, userID1, userID2).Scan(&count)
+	return count == 2, err
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"social/internal/db"
+	"social/internal/errors"
+	"social/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// AddFriend adds friendID back to the user's friends so their posts appear in the user's feed.
+// Friendships need consent: friend edges are only created by accepting a friend request, so this
+// only restores the user's side of a friendship that friendID still keeps.
+func AddFriend(ctx context.Context, userID, friendID string) error {
+	if userID == friendID {
+		return errors.ErrCannotFriendSelf
+	}
+	blocked, err := IsBlockedBetween(ctx, userID, friendID)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return errors.ErrUserBlocked
+	}
+	var consented bool
+	err = db.WriteDB.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM friends WHERE user_id = $1 AND friend_id = $2)", friendID, userID,
+	).Scan(&consented)
+	if err != nil {
+		return err
+	}
+	if !consented {
+		return errors.ErrFriendRequestRequired
+	}
+	_, err = db.WriteDB.ExecContext(ctx,
+		"INSERT INTO friends (user_id, friend_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		userID, friendID,
+	)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" { // foreign_key_violation
+			return errors.ErrUserNotFound
+		}
+		return err
+	}
+	invalidateFeedCache(ctx, userID)
+	scheduleSuggestionsRefresh(ctx, userID)
+	return nil
+}
+
+// RemoveFriend removes friendID from the user's friends
+func RemoveFriend(ctx context.Context, userID, friendID string) error {
+	res, err := db.WriteDB.ExecContext(ctx,
+		"DELETE FROM friends WHERE user_id = $1 AND friend_id = $2",
+		userID, friendID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errors.ErrFriendNotFound
+	}
+	invalidateFeedCache(ctx, userID)
+	scheduleSuggestionsRefresh(ctx, userID)
+	return nil
+}
+
+// ListFriends returns a page of the user's friends ordered by name
+func ListFriends(ctx context.Context, userID string, offset, limit int) ([]models.User, error) {
+	query := `
+		SELECT users.id, users.first_name, users.last_name, users.birthdate, users.biography, users.city
+		FROM friends
+		JOIN users ON users.id = friends.friend_id
+		WHERE friends.user_id = $1
+		ORDER BY users.last_name, users.first_name, users.id
+		OFFSET $2 LIMIT $3
+	`
+	rows, err := db.ReadDB.QueryContext(ctx, query, userID, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		var user models.User
+		err := rows.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Birthdate, &user.Biography, &user.City)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// invalidateFeedCache drops the cached friend_posts list so the next read rebuilds it from the friend graph
+func invalidateFeedCache(ctx context.Context, userIDs ...string) {
+	// Dropping the lock makes a rebuild that is in progress discard its snapshot
+	keys := make([]string, 0, 4*len(userIDs))
+	for _, id := range userIDs {
+		cacheKey := cacheKeyPrefix + id
+		keys = append(keys, cacheKey, cacheKey+feedEmptyKeySuffix, cacheKey+feedLockKeySuffix, cacheKey+feedPendingKeySuffix)
+	}
+	redisClient.Del(ctx, keys...)
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"social/internal/db"
+	"social/internal/models"
+	"social/internal/ws"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	likeCountKeyPrefix = "post_likes:"      // Like counter of a post, ahead of posts.like_count until flushed
+	dirtyLikesKey      = "post_likes_dirty" // Posts whose counters have not been written back yet
+	likeFlushInterval  = 5 * time.Second
+	likeFlushBatch     = 500
+)
+
+// LikePost adds the user's like to a post they can see and notifies the author
+func LikePost(ctx context.Context, userID, postID string) error {
+	post, err := visiblePost(ctx, userID, postID)
+	if err != nil {
+		return err
+	}
+	res, err := db.WriteDB.ExecContext(ctx,
+		"INSERT INTO post_likes (post_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		postID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	changeLikeCount(ctx, postID, 1)
+
+	if post.AuthorUserID != userID {
+		publishUserEvent(post.AuthorUserID, "", ws.PostLikedMessage{
+			Event:  ws.EventPostLiked,
+			PostID: postID,
+			UserID: userID,
+		})
+	}
+	return nil
+}
+
+// UnlikePost removes the user's like from a post. Removing a missing like is a no-op.
+func UnlikePost(ctx context.Context, userID, postID string) error {
+	if _, err := visiblePost(ctx, userID, postID); err != nil {
+		return err
+	}
+	res, err := db.WriteDB.ExecContext(ctx,
+		"DELETE FROM post_likes WHERE post_id = $1 AND user_id = $2", postID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	changeLikeCount(ctx, postID, -1)
+	return nil
+}
+
+// ListPostLikes returns a page of users who liked a post, most recent likes first
+func ListPostLikes(ctx context.Context, viewerID, postID string, offset, limit int) ([]models.User, error) {
+	if _, err := visiblePost(ctx, viewerID, postID); err != nil {
+		return nil, err
+	}
+	query := `
+		SELECT users.id, users.first_name, users.last_name, users.birthdate, users.biography, users.city
+		FROM post_likes
+		JOIN users ON users.id = post_likes.user_id
+		WHERE post_likes.post_id = $1
+		ORDER BY post_likes.created_at DESC, users.id
+		OFFSET $2 LIMIT $3
+	`
+	rows, err := db.ReadDB.QueryContext(ctx, query, postID, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		var user models.User
+		err := rows.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Birthdate, &user.Biography, &user.City)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// deleteUserLikes removes all likes of the user and returns the posts they were on
+func deleteUserLikes(ctx context.Context, tx *sql.Tx, userID string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, "DELETE FROM post_likes WHERE user_id = $1 RETURNING post_id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var postIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		postIDs = append(postIDs, id)
+	}
+	return postIDs, rows.Err()
+}
+
+// changeLikeCount applies a like delta to the Redis counter and marks it for write-back.
+// A counter that is not loaded is set to the count of post_likes on the primary, which already
+// contains the change and overrides whatever a concurrent read cached meanwhile.
+func changeLikeCount(ctx context.Context, postID string, delta int) {
+	key := likeCountKeyPrefix + postID
+	err := incrIfExists.Run(ctx, redisClient, []string{key}, delta, int(counterTTL.Seconds())).Err()
+	if err == redis.Nil {
+		var count int
+		err = db.WriteDB.QueryRowContext(ctx, "SELECT COUNT(*) FROM post_likes WHERE post_id = $1", postID).Scan(&count)
+		if err == nil {
+			err = redisClient.Set(ctx, key, count, counterTTL).Err()
+		}
+	}
+	if err != nil {
+		log.Printf("changeLikeCount: failed to update counter of post %s: %v", postID, err)
+		return
+	}
+	redisClient.SAdd(ctx, dirtyLikesKey, postID)
+}
+
+// RunLikeCountFlusher periodically writes changed like counters back to posts.like_count until ctx is done
+func RunLikeCountFlusher(ctx context.Context) {
+	ticker := time.NewTicker(likeFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			flushLikeCounts(ctx)
+		}
+	}
+}
+
+// flushLikeCounts recounts the changed posts' likes from post_likes rather than trusting the Redis
+// counters, and corrects loaded counters that drifted. A like that lands between the recount and
+// the correction marks the post changed again, so the next flush fixes the counter.
+func flushLikeCounts(ctx context.Context) {
+	for {
+		postIDs, err := redisClient.SPopN(ctx, dirtyLikesKey, likeFlushBatch).Result()
+		if err != nil || len(postIDs) == 0 {
+			if err != nil {
+				log.Printf("flushLikeCounts: failed to read changed counters: %v", err)
+			}
+			return
+		}
+		failed := false
+		for _, postID := range postIDs {
+			var count int
+			err := db.WriteDB.QueryRowContext(ctx, `
+				UPDATE posts SET like_count = (SELECT COUNT(*) FROM post_likes WHERE post_id = $1)
+				WHERE id = $1
+				RETURNING like_count
+			`, postID).Scan(&count)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err == nil {
+				err = redisClient.SetXX(ctx, likeCountKeyPrefix+postID, count, counterTTL).Err()
+			}
+			if err != nil {
+				log.Printf("flushLikeCounts: failed to write counter of post %s: %v", postID, err)
+				redisClient.SAdd(ctx, dirtyLikesKey, postID)
+				failed = true
+			}
+		}
+		// Failed counters are retried on the next tick
+		if failed || len(postIDs) < likeFlushBatch {
+			return
+		}
+	}
+}
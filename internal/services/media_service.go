@@ -0,0 +1,312 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"social/internal/db"
+	"social/internal/errors"
+	"social/internal/models"
+	"social/internal/storage"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	MaxMediaBytes        = 20 << 20 // Largest accepted upload
+	MaxAttachments       = 10       // Attachments per post
+	maxImagePixels       = 50_000_000
+	mediaKeyPrefix       = "media/"
+	sniffLength          = 512
+	mediaColumns         = "media.id, media.post_id, media.content_type, media.size_bytes, media.width, media.height"
+	defaultMediaURLRoute = "/media/"
+	mediaCleanupInterval = 10 * time.Minute
+	mediaUnattachedTTL   = 24 * time.Hour // How long an upload may wait to be attached to a post
+	mediaCleanupBatch    = 500
+)
+
+// allowedMediaTypes are the sniffed content types accepted for upload
+var allowedMediaTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"video/mp4":  true,
+	"video/webm": true,
+}
+
+var (
+	mediaStorage storage.Storage
+	mediaBaseURL = defaultMediaURLRoute
+)
+
+// SetMediaStorage configures where uploaded files are kept and the URL prefix they are served under
+func SetMediaStorage(s storage.Storage, baseURL string) {
+	mediaStorage = s
+	if baseURL != "" {
+		mediaBaseURL = strings.TrimSuffix(baseURL, "/") + "/"
+	}
+}
+
+// UploadMedia stores an uploaded file for the user. The content type is sniffed from the data,
+// not taken from the client, and image dimensions are read from the image header.
+func UploadMedia(ctx context.Context, userID string, r io.Reader) (*models.Attachment, error) {
+	// Spool to disk: the size must be known before the upload and the data is read twice
+	tmp, err := os.CreateTemp("", "media-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, io.LimitReader(r, MaxMediaBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if size > MaxMediaBytes {
+		return nil, errors.ErrMediaTooLarge
+	}
+	if size == 0 {
+		return nil, errors.ErrMediaEmpty
+	}
+
+	head := make([]byte, min(size, sniffLength))
+	if _, err := tmp.ReadAt(head, 0); err != nil {
+		return nil, err
+	}
+	contentType := http.DetectContentType(head)
+	if !allowedMediaTypes[contentType] {
+		return nil, errors.ErrUnsupportedMedia
+	}
+
+	attachment := models.Attachment{ContentType: contentType, Size: size}
+	if strings.HasPrefix(contentType, "image/") {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		cfg, _, err := image.DecodeConfig(tmp)
+		if err != nil {
+			return nil, errors.ErrUnsupportedMedia
+		}
+		if cfg.Width*cfg.Height > maxImagePixels {
+			return nil, errors.ErrMediaTooLarge
+		}
+		attachment.Width, attachment.Height = cfg.Width, cfg.Height
+	}
+
+	err = db.WriteDB.QueryRowContext(ctx, `
+		INSERT INTO media (owner_user_id, content_type, size_bytes, width, height)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, userID, contentType, size, nullInt(attachment.Width), nullInt(attachment.Height)).Scan(&attachment.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := mediaStorage.Put(ctx, mediaKeyPrefix+attachment.ID, tmp, size, contentType); err != nil {
+		db.WriteDB.ExecContext(ctx, "DELETE FROM media WHERE id = $1", attachment.ID)
+		return nil, err
+	}
+	_, err = db.WriteDB.ExecContext(ctx, "UPDATE media SET stored = true WHERE id = $1", attachment.ID)
+	if err != nil {
+		return nil, err
+	}
+	attachment.URL = mediaBaseURL + attachment.ID
+	return &attachment, nil
+}
+
+// OpenMedia opens a stored file for serving to the viewer, who is empty for anonymous requests.
+// Files of live posts are served to everyone except users blocked by or blocking the author, and
+// uploads not attached yet only to their owner. Files of deleted posts are not served.
+func OpenMedia(ctx context.Context, viewerID, mediaID string) (*models.Attachment, storage.Object, error) {
+	rows, err := db.ReadDB.QueryContext(ctx, `
+		SELECT `+mediaColumns+` FROM media
+		WHERE media.id = $1 AND media.stored AND (
+			(media.post_id IS NULL AND media.owner_user_id = NULLIF($2, '')::uuid) OR
+			EXISTS (
+				SELECT 1 FROM posts
+				WHERE posts.id = media.post_id AND posts.deleted_at IS NULL AND NOT EXISTS (
+					SELECT 1 FROM blocks
+					WHERE (blocks.blocker_id = posts.author_user_id AND blocks.blocked_id = NULLIF($2, '')::uuid)
+						OR (blocks.blocker_id = NULLIF($2, '')::uuid AND blocks.blocked_id = posts.author_user_id)
+				)
+			)
+		)`, mediaID, viewerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	attachments, err := scanAttachments(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(attachments) == 0 {
+		return nil, nil, errors.ErrMediaNotFound
+	}
+	obj, err := mediaStorage.Open(ctx, mediaKeyPrefix+mediaID)
+	if err == storage.ErrNotFound {
+		return nil, nil, errors.ErrMediaNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &attachments[0].Attachment, obj, nil
+}
+
+// attachMedia links the user's unattached uploads to a post, in the given order
+func attachMedia(ctx context.Context, tx *sql.Tx, userID, postID string, mediaIDs []string) error {
+	if len(mediaIDs) == 0 {
+		return nil
+	}
+	res, err := tx.ExecContext(ctx, `
+		UPDATE media SET post_id = $1, position = array_position($3::uuid[], id)
+		WHERE id = ANY($3::uuid[]) AND owner_user_id = $2 AND post_id IS NULL AND stored
+	`, postID, userID, pq.Array(mediaIDs))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if int(n) != len(mediaIDs) {
+		return errors.ErrMediaNotFound
+	}
+	return nil
+}
+
+// withAttachments fills in the attachments of posts
+func withAttachments(ctx context.Context, posts []models.Post) ([]models.Post, error) {
+	if len(posts) == 0 {
+		return posts, nil
+	}
+	ids := make([]string, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+	}
+	rows, err := db.ReadDB.QueryContext(ctx,
+		"SELECT "+mediaColumns+" FROM media WHERE media.post_id = ANY($1) ORDER BY media.position", pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := scanAttachments(rows)
+	if err != nil {
+		return nil, err
+	}
+	byPost := make(map[string][]models.Attachment)
+	for _, a := range attachments {
+		byPost[a.postID] = append(byPost[a.postID], a.Attachment)
+	}
+	for i := range posts {
+		posts[i].Attachments = byPost[posts[i].ID]
+	}
+	return posts, nil
+}
+
+// postAttachment is an attachment together with the post it belongs to
+type postAttachment struct {
+	models.Attachment
+	postID string
+}
+
+func scanAttachments(rows *sql.Rows) ([]postAttachment, error) {
+	defer rows.Close()
+	var attachments []postAttachment
+	for rows.Next() {
+		var a postAttachment
+		var postID sql.NullString
+		var width, height sql.NullInt64
+		if err := rows.Scan(&a.ID, &postID, &a.ContentType, &a.Size, &width, &height); err != nil {
+			return nil, err
+		}
+		a.postID = postID.String
+		a.Width, a.Height = int(width.Int64), int(height.Int64)
+		a.URL = mediaBaseURL + a.ID
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+// deleteUserMedia removes the user's media records and returns the storage keys of their files
+func deleteUserMedia(ctx context.Context, tx *sql.Tx, userID string) ([]string, error) {
+	return mediaKeys(tx.QueryContext(ctx, "DELETE FROM media WHERE owner_user_id = $1 RETURNING id", userID))
+}
+
+// deletePostMedia removes the media records of a post and returns the storage keys of their files
+func deletePostMedia(ctx context.Context, tx *sql.Tx, postID string) ([]string, error) {
+	return mediaKeys(tx.QueryContext(ctx, "DELETE FROM media WHERE post_id = $1 RETURNING id", postID))
+}
+
+// mediaKeys reads the media IDs returned by a query as storage keys
+func mediaKeys(rows *sql.Rows, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		keys = append(keys, mediaKeyPrefix+id)
+	}
+	return keys, rows.Err()
+}
+
+// RunMediaCleanup periodically deletes uploads that were never attached to a post, including
+// records whose upload failed halfway, until ctx is done
+func RunMediaCleanup(ctx context.Context) {
+	ticker := time.NewTicker(mediaCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cleanupMedia(ctx)
+		}
+	}
+}
+
+func cleanupMedia(ctx context.Context) {
+	cutoff := time.Now().Add(-mediaUnattachedTTL)
+	for {
+		// post_id is checked again on the deleted rows, so an upload attached meanwhile is kept
+		keys, err := mediaKeys(db.WriteDB.QueryContext(ctx, `
+			DELETE FROM media
+			WHERE id IN (SELECT id FROM media WHERE post_id IS NULL AND created_at < $1 LIMIT $2)
+			AND post_id IS NULL
+			RETURNING id
+		`, cutoff, mediaCleanupBatch))
+		if err != nil {
+			log.Printf("cleanupMedia: failed to delete stale uploads: %v", err)
+			return
+		}
+		deleteMediaFiles(ctx, keys)
+		if len(keys) < mediaCleanupBatch {
+			return
+		}
+	}
+}
+
+// deleteMediaFiles removes stored files; failures only leave orphaned blobs behind
+func deleteMediaFiles(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := mediaStorage.Delete(ctx, key); err != nil {
+			log.Printf("deleteMediaFiles: failed to delete %s: %v", key, err)
+		}
+	}
+}
+
+func nullInt(n int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(n), Valid: n > 0}
+}
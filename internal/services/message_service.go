@@ -1,51 +1,219 @@
 package services
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/binary"
 	"math"
+	"slices"
+	"social/internal/auth"
 	"social/internal/db"
+	"social/internal/errors"
 	"social/internal/models"
+	"social/internal/pagination"
 	"sort"
+	"strconv"
 	"time"
 )
 
-func SendMessage(fromUserID, toUserID, text string) error {
-	db := db.CitusDB // Use the Citus coordinator for sharded messages
-	query := `
+// SendMessage stores a message and pushes it to the recipient and the sender's other devices in real time
+func SendMessage(ctx context.Context, fromUserID, toUserID, text string) (*models.Message, error) {
+	blocked, err := IsBlockedBetween(ctx, fromUserID, toUserID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, errors.ErrUserBlocked
+	}
+
+	// The message and both sides of the conversation index are written in one distributed transaction
+	tx, err := db.CitusDB.BeginTx(ctx, nil) // Use the Citus coordinator for sharded messages
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	message := models.Message{FromUserID: fromUserID, ToUserID: toUserID, Text: text}
+	err = tx.QueryRowContext(ctx, `
 		INSERT INTO messages (from_user_id, to_user_id, text, created_at, shard_key)
 		VALUES ($1, $2, $3, $4, $5)
-	`
-	_, err := db.Exec(query, fromUserID, toUserID, text, time.Now(), calcShardKey(fromUserID, toUserID))
-	return err
+		RETURNING id, created_at
+	`, fromUserID, toUserID, text, time.Now(), calcShardKey(fromUserID, toUserID)).Scan(&message.ID, &message.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	// Both sides are written in user ID order, the same order MarkDialogRead locks them in,
+	// so concurrent messages and read marks of a pair cannot deadlock
+	sides := []struct {
+		userID, partnerID string
+		unread            int
+	}{{fromUserID, toUserID, 0}, {toUserID, fromUserID, 1}}
+	if toUserID < fromUserID {
+		sides[0], sides[1] = sides[1], sides[0]
+	}
+	for _, side := range sides {
+		if err := indexConversation(ctx, tx, side.userID, side.partnerID, message, side.unread); err != nil {
+			return nil, err
+		}
+	}
+	entryID, err := addUnreadOutbox(ctx, tx, toUserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	completeUnreadChange(ctx, toUserID, entryID)
+	go publishDialogMessage(message, auth.SessionIDFromContext(ctx))
+	return &message, nil
 }
 
-func GetDialog(userID1, userID2 string) ([]models.Message, error) {
+// MarkDialogRead marks the messages of a conversation read up to and including the given message.
+// Read marks only move forward. The unread count is recounted from the messages after the mark,
+// and the partner's side of the conversation records the mark as a read receipt.
+func MarkDialogRead(ctx context.Context, userID, partnerID string, messageID int64) error {
+	shardKey := calcShardKey(userID, partnerID)
+	var exists bool
+	err := db.CitusDB.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM messages
+			WHERE shard_key = $1 AND id = $4 AND
+			((from_user_id = $2 AND to_user_id = $3) OR (from_user_id = $3 AND to_user_id = $2))
+		)`, shardKey, userID, partnerID, messageID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.ErrMessageNotFound
+	}
+
+	tx, err := db.CitusDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Both sides are locked up front in user ID order, the order SendMessage writes them in. The lock
+	// makes a concurrent SendMessage wait, so its message is either counted below or added to the
+	// count after this transaction.
+	sides := [][2]string{{userID, partnerID}, {partnerID, userID}}
+	if partnerID < userID {
+		sides[0], sides[1] = sides[1], sides[0]
+	}
+	var lastRead int64
+	for _, side := range sides {
+		var readMark int64
+		err = tx.QueryRowContext(ctx, `
+			SELECT last_read_message_id FROM conversations
+			WHERE user_id = $1 AND partner_id = $2
+			FOR UPDATE
+		`, side[0], side[1]).Scan(&readMark)
+		if err != nil {
+			return err
+		}
+		if side[0] == userID {
+			lastRead = max(readMark, messageID)
+		}
+	}
+	var unread int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM messages
+		WHERE shard_key = $1 AND from_user_id = $2 AND to_user_id = $3 AND id > $4
+	`, shardKey, partnerID, userID, lastRead).Scan(&unread)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE conversations SET last_read_message_id = $3, unread_count = $4
+		WHERE user_id = $1 AND partner_id = $2
+	`, userID, partnerID, lastRead, unread)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE conversations SET partner_read_message_id = GREATEST(partner_read_message_id, $3)
+		WHERE user_id = $1 AND partner_id = $2
+	`, partnerID, userID, lastRead)
+	if err != nil {
+		return err
+	}
+	entryID, err := addUnreadOutbox(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	completeUnreadChange(ctx, userID, entryID)
+	return nil
+}
+
+// GetDialog returns a page of the conversation between two users in chronological order.
+// Without cursors it is the latest limit messages. With before it is the messages preceding
+// the cursor and next_cursor continues further back; with after it is the messages following
+// the cursor and next_cursor continues forward.
+func GetDialog(ctx context.Context, userID1, userID2 string, before, after *pagination.Cursor, limit int) (models.MessagePage, error) {
 	db := db.CitusDB // Use the Citus coordinator for sharded messages
 	query := `
-		SELECT from_user_id, to_user_id, text, created_at
+		SELECT id, from_user_id, to_user_id, text, created_at
 		FROM messages
 		WHERE shard_key = $1 AND
-		((from_user_id = $2 AND to_user_id = $3) OR (from_user_id = $3 AND to_user_id = $2))
-		ORDER BY created_at ASC
-	`
-	rows, err := db.Query(query, calcShardKey(userID1, userID2), userID1, userID2)
+		((from_user_id = $2 AND to_user_id = $3) OR (from_user_id = $3 AND to_user_id = $2))`
+	args := []any{calcShardKey(userID1, userID2), userID1, userID2}
+	order := " ORDER BY created_at DESC, id DESC"
+	switch {
+	case after != nil:
+		query += " AND (created_at, id) > ($4::timestamp, $5::bigint)"
+		args = append(args, after.CreatedAt, after.ID)
+		order = " ORDER BY created_at ASC, id ASC"
+	case before != nil:
+		query += " AND (created_at, id) < ($4::timestamp, $5::bigint)"
+		args = append(args, before.CreatedAt, before.ID)
+	}
+	query += order + " LIMIT $" + strconv.Itoa(len(args)+1)
+	args = append(args, limit)
+
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return models.MessagePage{}, err
 	}
 	defer rows.Close()
 
-	var messages []models.Message
+	messages := []models.Message{}
 	for rows.Next() {
 		var message models.Message
-		err := rows.Scan(&message.FromUserID, &message.ToUserID, &message.Text, &message.CreatedAt)
+		err := rows.Scan(&message.ID, &message.FromUserID, &message.ToUserID, &message.Text, &message.CreatedAt)
 		if err != nil {
-			return nil, err
+			return models.MessagePage{}, err
 		}
 		messages = append(messages, message)
 	}
+	if err := rows.Err(); err != nil {
+		return models.MessagePage{}, err
+	}
+	if after == nil {
+		slices.Reverse(messages)
+	}
+
+	page := models.MessagePage{Messages: messages}
+	if len(messages) == 0 {
+		return page, nil
+	}
+	// next_cursor continues from the far end of the page in the direction of travel,
+	// prev_cursor turns back from the near end
+	far, near := messages[0], messages[len(messages)-1]
+	if after != nil {
+		far, near = near, far
+	}
+	if len(messages) == limit {
+		page.NextCursor = messageCursor(far)
+	}
+	page.PrevCursor = messageCursor(near)
+	return page, nil
+}
 
-	return messages, nil
+func messageCursor(message models.Message) string {
+	return pagination.Cursor{CreatedAt: message.CreatedAt, ID: strconv.FormatInt(message.ID, 10)}.Encode()
 }
 
 func calcShardKey(fromID, toID string) int64 {
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"log"
+	"social/internal/db"
+	"social/internal/models"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/lib/pq"
+)
+
+const (
+	counterTTL                   = 24 * time.Hour
+	postCountersVersionKeyPrefix = "post_counters_version:" // Bumped whenever a post's comment or repost count changes
+)
+
+// incrIfExists changes a counter only when it is loaded, so that a cold counter is never
+// initialized from a partial delta
+var incrIfExists = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	local n = redis.call("INCRBY", KEYS[1], ARGV[1])
+	redis.call("EXPIRE", KEYS[1], ARGV[2])
+	return n
+end
+return false
+`)
+
+// fillPostCounters caches like, comment and repost counters (KEYS[1..3]) loaded from the posts table
+// unless the post's counter version (KEYS[4]) changed since the load started. A counter that is
+// already loaded is left alone.
+var fillPostCounters = redis.NewScript(`
+if (redis.call("GET", KEYS[4]) or "0") ~= ARGV[1] then
+	return 0
+end
+for i = 1, 3 do
+	redis.call("SET", KEYS[i], ARGV[i + 2], "EX", ARGV[2], "NX")
+end
+return 1
+`)
+
+// dropPostCounter drops a comment or repost counter after a committed change, to be reloaded from the posts table
+func dropPostCounter(ctx context.Context, prefix, postID string) {
+	keys := []string{prefix + postID, postCountersVersionKeyPrefix + postID}
+	if err := dropVersioned.Run(ctx, redisClient, keys, int(counterTTL.Seconds())).Err(); err != nil {
+		log.Printf("dropPostCounter: failed to drop counter %s: %v", keys[0], err)
+	}
+}
+
+// withCounters fills in like, comment and repost counters and the viewer's likes. Counters missing from Redis
+// are loaded from the posts table on the primary, which is current whenever the counter is not loaded;
+// a replica may lag behind, and a lagging value would stay cached until the counter expires. Comment and
+// repost changes drop their counter instead of adjusting it, so a change can never be applied twice or lost.
+func withCounters(ctx context.Context, viewerID string, posts []models.Post) ([]models.Post, error) {
+	if len(posts) == 0 {
+		return posts, nil
+	}
+	ids := make([]string, len(posts))
+	prefixes := []string{likeCountKeyPrefix, commentCountKeyPrefix, repostCountKeyPrefix}
+	keys := make([]string, 0, len(prefixes)*len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+		for _, prefix := range prefixes {
+			keys = append(keys, prefix+post.ID)
+		}
+	}
+
+	// counts[id] holds the counters in the order of prefixes
+	counts := make(map[string][]int, len(posts))
+	var missing []string
+	cached, err := redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		cached = make([]any, len(keys))
+	}
+	for i, id := range ids {
+		values := make([]int, len(prefixes))
+		for j := range prefixes {
+			value, ok := cached[i*len(prefixes)+j].(string)
+			if !ok {
+				values = nil
+				break
+			}
+			values[j], _ = strconv.Atoi(value)
+		}
+		if values == nil {
+			missing = append(missing, id)
+			continue
+		}
+		counts[id] = values
+	}
+
+	if len(missing) > 0 {
+		// Versions are read before the posts table, so a change committed after the query started bumps them
+		versionKeys := make([]string, len(missing))
+		for i, id := range missing {
+			versionKeys[i] = postCountersVersionKeyPrefix + id
+		}
+		versions := make(map[string]string, len(missing))
+		if cachedVersions, err := redisClient.MGet(ctx, versionKeys...).Result(); err == nil {
+			for i, id := range missing {
+				versions[id] = "0"
+				if version, ok := cachedVersions[i].(string); ok {
+					versions[id] = version
+				}
+			}
+		}
+
+		rows, err := db.WriteDB.QueryContext(ctx,
+			"SELECT id, like_count, comment_count, repost_count FROM posts WHERE id = ANY($1)", pq.Array(missing))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		pipe := redisClient.Pipeline()
+		for rows.Next() {
+			var id string
+			values := make([]int, len(prefixes))
+			if err := rows.Scan(&id, &values[0], &values[1], &values[2]); err != nil {
+				return nil, err
+			}
+			counts[id] = values
+			version, ok := versions[id]
+			if !ok {
+				continue
+			}
+			keys := make([]string, 0, len(prefixes)+1)
+			for _, prefix := range prefixes {
+				keys = append(keys, prefix+id)
+			}
+			keys = append(keys, postCountersVersionKeyPrefix+id)
+			fillPostCounters.Eval(ctx, pipe, keys, version, int(counterTTL.Seconds()), values[0], values[1], values[2])
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Printf("withCounters: failed to cache post counters: %v", err)
+		}
+	}
+
+	liked := make(map[string]struct{})
+	if viewerID != "" {
+		rows, err := db.ReadDB.QueryContext(ctx,
+			"SELECT post_id FROM post_likes WHERE user_id = $1 AND post_id = ANY($2)", viewerID, pq.Array(ids))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				return nil, err
+			}
+			liked[id] = struct{}{}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range posts {
+		if values, ok := counts[posts[i].ID]; ok {
+			posts[i].LikeCount, posts[i].CommentCount, posts[i].RepostCount = values[0], values[1], values[2]
+		}
+		_, posts[i].LikedByMe = liked[posts[i].ID]
+	}
+	return posts, nil
+}
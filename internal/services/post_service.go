@@ -2,14 +2,23 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
-	"fmt"
+	"log"
 	"social/internal/db"
+	"social/internal/errors"
 	"social/internal/models"
+	"social/internal/pagination"
+	"social/internal/validation"
 	"social/internal/ws"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/lib/pq"
 )
 
 var (
@@ -19,99 +28,531 @@ var (
 )
 
 const (
-	cacheKeyPrefix = "friend_posts:"
-	cacheTTL       = 5 * time.Minute // Cache expiration time
-	cacheLimit     = 1000            // Maximum number of posts to keep in cache
+	cacheKeyPrefix   = "friend_feed:" // Post IDs in a user's feed, scored by creation time in microseconds
+	postKeyPrefix    = "post:"        // Cached post bodies shared by all feeds
+	cacheTTL         = 24 * time.Hour // Idle feeds expire and are rebuilt on the next read
+	postCacheTTL     = time.Hour
+	cacheLimit       = 1000 // Maximum number of posts to keep in a feed
+	feedTmpKeySuffix = ":rebuild"
+
+	feedEmptyKeySuffix   = ":empty"   // Marks a feed that was rebuilt with no posts
+	feedLockKeySuffix    = ":lock"    // Held while a feed is rebuilt
+	feedPendingKeySuffix = ":pending" // Posts fanned out while a feed is rebuilt, scored like the feed
+	feedRebuildLockTTL   = 10 * time.Second
+	feedRebuildPoll      = 50 * time.Millisecond
+	feedRebuildWaits     = 40
 )
 
-func GetFriendPosts(ctx context.Context, userID string, offset, limit int) ([]models.Post, error) {
-	cacheKey := fmt.Sprintf("%s%s", cacheKeyPrefix, userID)
-
-	// Check if posts are cached
-	cachedPosts, err := redisClient.LRange(ctx, cacheKey, 0, -1).Result()
-	if err == nil && len(cachedPosts) > 0 {
-		var posts []models.Post
-		for _, postJSON := range cachedPosts {
-			var post models.Post
-			if err := json.Unmarshal([]byte(postJSON), &post); err == nil {
-				posts = append(posts, post)
+// GetFriendPosts returns the page of the user's feed that follows the cursor, or the first page when after
+// is nil. The latest cacheLimit post IDs are materialized in Redis by fan-out on write, and posts of followed
+// pull-mode authors are merged in at read time. Pages past the materialized feed are read from Postgres.
+func GetFriendPosts(ctx context.Context, userID string, after *pagination.Cursor, limit int) (models.PostPage, error) {
+	celebrities, err := followedCelebrities(ctx, userID)
+	if err != nil {
+		return models.PostPage{}, err
+	}
+
+	sources := []postIDSource{func(after *pagination.Cursor, count int64) ([]redis.Z, bool, error) {
+		return feedEntries(ctx, userID, after, count)
+	}}
+	for _, authorID := range celebrities {
+		sources = append(sources, func(after *pagination.Cursor, count int64) ([]redis.Z, bool, error) {
+			return authorEntries(ctx, authorID, after, count)
+		})
+	}
+
+	posts, complete, err := mergePostsBefore(ctx, sources, after, limit)
+	if err != nil {
+		return models.PostPage{}, err
+	}
+	if !complete {
+		posts, err = queryFriendPostsBefore(ctx, userID, after, limit)
+		if err != nil {
+			return models.PostPage{}, err
+		}
+	}
+	if posts, err = decoratePosts(ctx, userID, posts); err != nil {
+		return models.PostPage{}, err
+	}
+	return postPage(posts, limit), nil
+}
+
+// GetFriendPostsByOffset returns a feed page by offset.
+//
+// Deprecated: offsets shift as new posts arrive; use GetFriendPosts with a cursor.
+func GetFriendPostsByOffset(ctx context.Context, userID string, offset, limit int) (models.PostPage, error) {
+	var posts []models.Post
+	var err error
+	if offset >= cacheLimit {
+		posts, err = queryFriendPosts(ctx, userID, offset, limit)
+	} else {
+		var celebrities []string
+		celebrities, err = followedCelebrities(ctx, userID)
+		if err != nil {
+			return models.PostPage{}, err
+		}
+		if len(celebrities) > 0 {
+			posts, err = mergeFeed(ctx, userID, celebrities, offset, limit)
+		} else {
+			var ids []string
+			ids, err = feedPostIDs(ctx, userID, int64(offset), int64(min(offset+limit, cacheLimit)-1))
+			if err == nil {
+				posts, err = loadPosts(ctx, ids)
 			}
 		}
-		// Apply offset and limit to the cached posts
-		start := offset
-		end := offset + limit
-		if start > len(posts) {
-			return []models.Post{}, nil
+	}
+	if err == nil {
+		posts, err = decoratePosts(ctx, userID, posts)
+	}
+	if err != nil {
+		return models.PostPage{}, err
+	}
+	return postPage(posts, limit), nil
+}
+
+// postPage wraps a page of posts, pointing the next cursor at the last post when the page is full
+func postPage(posts []models.Post, limit int) models.PostPage {
+	page := models.PostPage{Posts: posts}
+	if len(posts) == limit && limit > 0 {
+		if cursor, err := postCursor(posts[len(posts)-1]); err == nil {
+			page.NextCursor = cursor.Encode()
+		}
+	}
+	return page
+}
+
+func postCursor(post models.Post) (pagination.Cursor, error) {
+	createdAt, err := time.Parse(time.RFC3339Nano, post.CreatedAt)
+	return pagination.Cursor{CreatedAt: createdAt, ID: post.ID}, err
+}
+
+// feedPostIDs reads a range of the materialized feed, falling back to Postgres when it cannot be materialized
+func feedPostIDs(ctx context.Context, userID string, start, stop int64) ([]string, error) {
+	var ids *redis.StringSliceCmd
+	ok, err := readFeed(ctx, userID, func(pipe redis.Pipeliner) {
+		ids = pipe.ZRevRange(ctx, cacheKeyPrefix+userID, start, stop)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		entries, err := queryFeedEntries(ctx, db.ReadDB, userID, start, stop)
+		return entryIDs(entries), err
+	}
+	return ids.Val(), nil
+}
+
+// feedEntries reads up to count feed entries that follow the cursor. A feed that cannot be
+// materialized is reported as capped and empty, so the caller reads Postgres instead.
+func feedEntries(ctx context.Context, userID string, after *pagination.Cursor, count int64) ([]redis.Z, bool, error) {
+	key := cacheKeyPrefix + userID
+	var entries func() []redis.Z
+	var size *redis.IntCmd
+	ok, err := readFeed(ctx, userID, func(pipe redis.Pipeliner) {
+		entries = zrangeAfter(ctx, pipe, key, after, count)
+		size = pipe.ZCard(ctx, key)
+	})
+	if err != nil || !ok {
+		return nil, true, err
+	}
+	return entries(), size.Val() >= cacheLimit, nil
+}
+
+// readFeed queues read on a pipeline together with the feed's existence checks, rebuilding the feed
+// from Postgres when it is missing. The reads see the feed atomically, an empty feed reads as a
+// missing key. When another reader keeps rebuilding the feed, it gives up and returns false.
+func readFeed(ctx context.Context, userID string, read func(pipe redis.Pipeliner)) (bool, error) {
+	cacheKey := cacheKeyPrefix + userID
+	for attempt := 0; attempt < feedRebuildWaits; attempt++ {
+		pipe := redisClient.TxPipeline()
+		exists := pipe.Exists(ctx, cacheKey, cacheKey+feedEmptyKeySuffix)
+		read(pipe)
+		pipe.Expire(ctx, cacheKey, cacheTTL)
+		pipe.Expire(ctx, cacheKey+feedEmptyKeySuffix, cacheTTL)
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return false, err
+		}
+		if exists.Val() > 0 {
+			return true, nil
+		}
+		rebuilt, err := rebuildFeed(ctx, userID)
+		if err != nil {
+			return false, err
 		}
-		if end > len(posts) {
-			end = len(posts)
+		if !rebuilt {
+			time.Sleep(feedRebuildPoll)
 		}
-		return posts[start:end], nil
 	}
+	return false, nil
+}
 
-	// Fetch posts from the database
-	query := `
-		SELECT posts.id, posts.text, posts.created_at, posts.author_user_id
-		FROM posts
-		JOIN friends ON posts.author_user_id = friends.friend_id
-		WHERE friends.user_id = $1
-		ORDER BY posts.created_at DESC
-		OFFSET $2 LIMIT $3
-	`
-	rows, err := db.ReadDB.Query(query, userID, offset, limit)
+// zrangeAfter queues a read of up to count entries of a newest-first post set that follow the cursor,
+// or the newest entries when it is nil. The result is available once the pipeline has run.
+func zrangeAfter(ctx context.Context, pipe redis.Pipeliner, key string, after *pagination.Cursor, count int64) func() []redis.Z {
+	if after == nil {
+		newest := pipe.ZRevRangeWithScores(ctx, key, 0, count-1)
+		return newest.Val
+	}
+	// Posts created in the cursor's microsecond are ordered by ID like in Postgres; only those below
+	// the cursor's ID follow it. Everything else is seeked by score.
+	score := strconv.FormatInt(after.CreatedAt.UnixMicro(), 10)
+	tied := pipe.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Min: score, Max: score})
+	older := pipe.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Min: "-inf", Max: "(" + score, Count: count})
+	return func() []redis.Z {
+		var entries []redis.Z
+		for _, entry := range tied.Val() {
+			if entry.Member.(string) < after.ID {
+				entries = append(entries, entry)
+			}
+		}
+		entries = append(entries, older.Val()...)
+		return entries[:min(int64(len(entries)), count)]
+	}
+}
+
+// queryFeedEntries reads a range of the latest feed posts straight from Postgres, scored like the cached feed
+func queryFeedEntries(ctx context.Context, conn *sql.DB, userID string, start, stop int64) ([]redis.Z, error) {
+	rows, err := conn.QueryContext(ctx,
+		friendPostsQuery("posts.id, posts.created_at", "")+" OFFSET $2 LIMIT $3", userID, start, stop-start+1)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return scanEntries(rows)
+}
 
-	var posts []models.Post
+// scanEntries reads rows of post IDs and creation times as sorted set entries
+func scanEntries(rows *sql.Rows) ([]redis.Z, error) {
+	defer rows.Close()
+	entries := []redis.Z{}
 	for rows.Next() {
+		var id string
+		var createdAt time.Time
+		if err := rows.Scan(&id, &createdAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, redis.Z{Score: float64(createdAt.UnixMicro()), Member: id})
+	}
+	return entries, rows.Err()
+}
+
+func entryIDs(entries []redis.Z) []string {
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.Member.(string)
+	}
+	return ids
+}
+
+// postScore returns the sorted set score of a post created at createdAt, as formatted in models.Post.
+// Posts announced without a creation time are scored as created now.
+func postScore(createdAt string) int64 {
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return time.Now().UnixMicro()
+	}
+	return t.UnixMicro()
+}
+
+// rebuildFeed materializes the latest cacheLimit feed post IDs. It runs under a per-user lock and reads
+// the primary, so the snapshot holds every post committed before it. Posts fanned out while the
+// rebuild runs are collected in a pending set and merged in, and the list is built under a temporary
+// key and renamed into place so that readers never see a partial feed. An empty feed is recorded with
+// a marker. It returns false when another rebuild holds the lock.
+func rebuildFeed(ctx context.Context, userID string) (bool, error) {
+	cacheKey := cacheKeyPrefix + userID
+	lockKey := cacheKey + feedLockKeySuffix
+	token := randomToken()
+	locked, err := redisClient.SetNX(ctx, lockKey, token, feedRebuildLockTTL).Result()
+	if err != nil || !locked {
+		return false, err
+	}
+	defer releaseFeedLock.Run(ctx, redisClient, []string{lockKey}, token)
+
+	entries, err := queryFeedEntries(ctx, db.WriteDB, userID, 0, cacheLimit-1)
+	if err != nil {
+		return false, err
+	}
+	args := make([]any, 0, 2*len(entries)+3)
+	args = append(args, token, cacheLimit, int(cacheTTL.Seconds()))
+	for _, entry := range entries {
+		args = append(args, entry.Score, entry.Member)
+	}
+	keys := []string{cacheKey, cacheKey + feedTmpKeySuffix, cacheKey + feedPendingKeySuffix, lockKey, cacheKey + feedEmptyKeySuffix}
+	err = finishFeedRebuild.Run(ctx, redisClient, keys, args...).Err()
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// finishFeedRebuild installs a rebuilt feed unless the lock was lost, e.g. to invalidateFeedCache.
+// Pending posts are added to the snapshot, which may already have them.
+// KEYS: feed, tmp, pending, lock, empty marker. ARGV: lock token, limit, TTL, score and post ID pairs.
+var finishFeedRebuild = redis.NewScript(`
+if redis.call("GET", KEYS[4]) ~= ARGV[1] then
+	return false
+end
+redis.call("DEL", KEYS[2])
+for i = 4, #ARGV, 2 do
+	redis.call("ZADD", KEYS[2], ARGV[i], ARGV[i + 1])
+end
+if redis.call("EXISTS", KEYS[3]) == 1 then
+	redis.call("ZUNIONSTORE", KEYS[2], 2, KEYS[2], KEYS[3])
+	redis.call("DEL", KEYS[3])
+end
+if redis.call("EXISTS", KEYS[2]) == 0 then
+	redis.call("DEL", KEYS[1])
+	redis.call("SET", KEYS[5], 1, "EX", ARGV[3])
+	return 0
+end
+redis.call("ZREMRANGEBYRANK", KEYS[2], 0, -tonumber(ARGV[2]) - 1)
+redis.call("RENAME", KEYS[2], KEYS[1])
+redis.call("EXPIRE", KEYS[1], ARGV[3])
+redis.call("DEL", KEYS[5])
+return 1
+`)
+
+var releaseFeedLock = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// pushToFeed adds a post to a feed and trims it to the newest posts. A feed marked empty becomes
+// a one-post feed, a feed being rebuilt gets the post in its pending set, and a feed that is not
+// materialized is skipped; it includes the post once it is rebuilt.
+// KEYS: feed, empty marker, lock, pending. ARGV: post ID, limit, TTL, score.
+var pushToFeed = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	redis.call("ZADD", KEYS[1], ARGV[4], ARGV[1])
+	redis.call("ZREMRANGEBYRANK", KEYS[1], 0, -tonumber(ARGV[2]) - 1)
+	return 1
+end
+if redis.call("EXISTS", KEYS[2]) == 1 then
+	redis.call("DEL", KEYS[2])
+	redis.call("ZADD", KEYS[1], ARGV[4], ARGV[1])
+	redis.call("EXPIRE", KEYS[1], ARGV[3])
+	return 1
+end
+if redis.call("EXISTS", KEYS[3]) == 1 then
+	redis.call("ZADD", KEYS[4], ARGV[4], ARGV[1])
+	redis.call("EXPIRE", KEYS[4], ARGV[3])
+	return 2
+end
+return 0
+`)
+
+// pushToFeeds adds a new post to the followers' materialized feeds
+func pushToFeeds(ctx context.Context, followerIDs []string, postID, createdAt string) {
+	score := postScore(createdAt)
+	pipe := redisClient.Pipeline()
+	for _, fid := range followerIDs {
+		cacheKey := cacheKeyPrefix + fid
+		keys := []string{cacheKey, cacheKey + feedEmptyKeySuffix, cacheKey + feedLockKeySuffix, cacheKey + feedPendingKeySuffix}
+		// Eval rather than EvalSha: a pipeline cannot fall back when the script is not loaded
+		pushToFeed.Eval(ctx, pipe, keys, postID, cacheLimit, int(cacheTTL.Seconds()), score)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("pushToFeeds: failed to push post %s: %v", postID, err)
+	}
+}
+
+// removeFromFeeds drops a deleted post from the followers' materialized feeds
+func removeFromFeeds(ctx context.Context, followerIDs []string, postID string) {
+	pipe := redisClient.Pipeline()
+	for _, fid := range followerIDs {
+		pipe.ZRem(ctx, cacheKeyPrefix+fid, postID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("removeFromFeeds: failed to remove post %s: %v", postID, err)
+	}
+}
+
+// loadPosts returns posts in the order of ids, reading bodies from the post cache and
+// falling back to Postgres for misses. Deleted posts are skipped. Rows read from the replica
+// are not cached: it may lag behind an edit or a deletion, and the cache would keep the stale
+// body after the writer already dropped it. The cache is filled by writes only.
+func loadPosts(ctx context.Context, ids []string) ([]models.Post, error) {
+	posts := make([]models.Post, 0, len(ids))
+	if len(ids) == 0 {
+		return posts, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = postKeyPrefix + id
+	}
+	cached, err := redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		cached = make([]any, len(ids))
+	}
+
+	byID := make(map[string]models.Post, len(ids))
+	var missing []string
+	for i, value := range cached {
 		var post models.Post
-		err := rows.Scan(&post.ID, &post.Text, &post.CreatedAt, &post.AuthorUserID)
+		if postJSON, ok := value.(string); ok && json.Unmarshal([]byte(postJSON), &post) == nil {
+			byID[ids[i]] = post
+		} else {
+			missing = append(missing, ids[i])
+		}
+	}
+
+	if len(missing) > 0 {
+		rows, err := db.ReadDB.QueryContext(ctx,
+			"SELECT "+postColumns+" FROM posts WHERE posts.id = ANY($1) AND posts.deleted_at IS NULL",
+			pq.Array(missing))
 		if err != nil {
 			return nil, err
 		}
-		posts = append(posts, post)
+		defer rows.Close()
+		for rows.Next() {
+			post, err := scanPost(rows)
+			if err != nil {
+				return nil, err
+			}
+			byID[post.ID] = post
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
 	}
 
-	if err = rows.Err(); err != nil {
+	for _, id := range ids {
+		if post, ok := byID[id]; ok {
+			posts = append(posts, post)
+		}
+	}
+	return posts, nil
+}
+
+const postColumns = "posts.id, posts.text, posts.created_at, posts.updated_at, posts.author_user_id, posts.repost_of"
+
+// queryFriendPosts reads a feed page straight from Postgres
+func queryFriendPosts(ctx context.Context, userID string, offset, limit int) ([]models.Post, error) {
+	rows, err := db.ReadDB.QueryContext(ctx, friendPostsQuery(postColumns, "")+" OFFSET $2 LIMIT $3", userID, offset, limit)
+	if err != nil {
 		return nil, err
 	}
+	return scanPosts(rows)
+}
 
-	// Cache the posts
-	for _, post := range posts {
-		postJSON, err := json.Marshal(post)
-		if err == nil {
-			redisClient.LPush(ctx, cacheKey, postJSON)
-		}
+// queryFriendPostsBefore reads the feed page that follows the cursor straight from Postgres
+func queryFriendPostsBefore(ctx context.Context, userID string, after *pagination.Cursor, limit int) ([]models.Post, error) {
+	if after == nil {
+		return queryFriendPosts(ctx, userID, 0, limit)
 	}
-	// Trim the cache to the last 1000 entries
-	redisClient.LTrim(ctx, cacheKey, 0, cacheLimit-1)
-	redisClient.Expire(ctx, cacheKey, cacheTTL)
+	query := friendPostsQuery(postColumns, "AND (posts.created_at, posts.id) < ($2::timestamp, $3::uuid)") + " LIMIT $4"
+	rows, err := db.ReadDB.QueryContext(ctx, query, userID, after.CreatedAt, after.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanPosts(rows)
+}
+
+func scanPosts(rows *sql.Rows) ([]models.Post, error) {
+	defer rows.Close()
 
+	posts := []models.Post{}
+	for rows.Next() {
+		post, err := scanPost(rows)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 	return posts, nil
 }
 
-func CreatePost(userID, text string) (string, error) {
-	var postID string
-	err := db.WriteDB.QueryRow(
-		"INSERT INTO posts (author_user_id, text) VALUES ($1, $2) RETURNING id",
-		userID, text,
-	).Scan(&postID)
+// friendPostsQuery selects the user's feed ($1) newest first, without posts hidden by blocks.
+// condition is appended to the WHERE clause.
+func friendPostsQuery(columns, condition string) string {
+	return `
+		SELECT ` + columns + `
+		FROM posts
+		JOIN friends ON posts.author_user_id = friends.friend_id
+		WHERE friends.user_id = $1
+		AND posts.deleted_at IS NULL
+		AND NOT EXISTS (
+			SELECT 1 FROM blocks
+			WHERE (blocks.blocker_id = $1 AND blocks.blocked_id = posts.author_user_id)
+			OR (blocks.blocker_id = posts.author_user_id AND blocks.blocked_id = $1)
+		)
+		` + condition + `
+		ORDER BY posts.created_at DESC, posts.id DESC`
+}
+
+// randomToken returns a random hex string, e.g. to tell lock holders apart
+func randomToken() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func cachePost(ctx context.Context, post models.Post) {
+	if postJSON, err := json.Marshal(post); err == nil {
+		redisClient.Set(ctx, postKeyPrefix+post.ID, postJSON, postCacheTTL)
+	}
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanPost reads a post selected with postColumns
+func scanPost(row rowScanner) (models.Post, error) {
+	var post models.Post
+	var updatedAt sql.NullTime
+	var repostOf sql.NullString
+	err := row.Scan(&post.ID, &post.Text, &post.CreatedAt, &updatedAt, &post.AuthorUserID, &repostOf)
+	post.UpdatedAt = formatNullTime(updatedAt)
+	post.RepostOfID = repostOf.String
+	return post, err
+}
+
+// CreatePost stores a post with its attachments, tags and mentions, fans it out to the followers' feeds
+// through the feed shard queues and notifies mentioned users
+func CreatePost(userID, text string, attachmentIDs []string) (string, error) {
+	ctx := context.Background()
+	tx, err := db.WriteDB.BeginTx(ctx, nil)
 	if err != nil {
 		return "", err
 	}
+	defer tx.Rollback()
 
-	// Get friend IDs to notify
-	friendIDs, err := GetFriendIDs(userID)
-	if err == nil && len(friendIDs) > 0 {
-		ws.NotifyFriends(friendIDs, ws.PostFeedPostedMessage{
-			PostID:       postID,
-			PostText:     text,
-			AuthorUserID: userID,
-		})
+	post, err := scanPost(tx.QueryRowContext(ctx,
+		"INSERT INTO posts (author_user_id, text) VALUES ($1, $2) RETURNING "+postColumns,
+		userID, text,
+	))
+	if err != nil {
+		return "", err
+	}
+	if err := attachMedia(ctx, tx, userID, post.ID, attachmentIDs); err != nil {
+		return "", err
+	}
+	mentioned, err := indexPostText(ctx, tx, post.ID, text)
+	if err != nil {
+		return "", err
 	}
-	return postID, nil
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	cachePost(ctx, post)
+
+	// Шардинг: публикуем задачи в очереди feed_shard_{N}
+	go PublishPostEvent(ws.PostFeedPostedMessage{
+		PostID:       post.ID,
+		PostText:     text,
+		AuthorUserID: userID,
+		CreatedAt:    post.CreatedAt,
+	})
+	notifyMentions(post, mentioned)
+	return post.ID, nil
 }
 
 // getFriendIDs returns a slice of user IDs who are friends with the given user
@@ -130,3 +571,186 @@ func GetFriendIDs(userID string) ([]string, error) {
 	}
 	return ids, nil
 }
+
+// GetPost returns a single post with its counters and reposted content. Deleted posts and posts hidden by a block are reported as not found.
+func GetPost(ctx context.Context, viewerID, postID string) (*models.Post, error) {
+	post, err := visiblePost(ctx, viewerID, postID)
+	if err != nil {
+		return nil, err
+	}
+	posts, err := decoratePosts(ctx, viewerID, []models.Post{*post})
+	if err != nil {
+		return nil, err
+	}
+	return &posts[0], nil
+}
+
+// visiblePost reads a post the viewer is allowed to see
+func visiblePost(ctx context.Context, viewerID, postID string) (*models.Post, error) {
+	post, err := scanPost(db.ReadDB.QueryRowContext(ctx, `
+		SELECT `+postColumns+`
+		FROM posts
+		WHERE posts.id = $1 AND posts.deleted_at IS NULL
+		AND NOT EXISTS (
+			SELECT 1 FROM blocks
+			WHERE (blocks.blocker_id = $2 AND blocks.blocked_id = posts.author_user_id)
+			OR (blocks.blocker_id = posts.author_user_id AND blocks.blocked_id = $2)
+		)
+	`, postID, viewerID))
+	if err == sql.ErrNoRows {
+		return nil, errors.ErrPostNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+// UpdatePost replaces the text of the user's post and propagates the change to followers' feeds.
+// Only users who were not mentioned before are notified.
+func UpdatePost(ctx context.Context, userID, postID, text string) (*models.Post, error) {
+	tx, err := db.WriteDB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if strings.TrimSpace(text) == "" {
+		// Only posts with attachments and reposts may go without text
+		var bare bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT repost_of IS NULL AND NOT EXISTS (SELECT 1 FROM media WHERE media.post_id = posts.id)
+			FROM posts
+			WHERE id = $1 AND author_user_id = $2 AND deleted_at IS NULL
+			FOR UPDATE
+		`, postID, userID).Scan(&bare)
+		if err == sql.ErrNoRows {
+			return nil, postOwnershipError(ctx, postID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if bare {
+			return nil, validation.Errors{{Field: "text", Message: "is required"}}
+		}
+	}
+
+	post, err := scanPost(tx.QueryRowContext(ctx, `
+		UPDATE posts SET text = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND author_user_id = $3 AND deleted_at IS NULL
+		RETURNING `+postColumns,
+		text, postID, userID))
+	if err == sql.ErrNoRows {
+		return nil, postOwnershipError(ctx, postID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	mentioned, err := indexPostText(ctx, tx, post.ID, text)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	cachePost(ctx, post)
+	notifyMentions(post, mentioned)
+
+	go PublishPostEvent(ws.PostFeedPostedMessage{
+		Event:        ws.EventPostUpdated,
+		PostID:       post.ID,
+		PostText:     post.Text,
+		AuthorUserID: post.AuthorUserID,
+		RepostOf:     post.RepostOfID,
+		UpdatedAt:    post.UpdatedAt,
+	})
+	return &post, nil
+}
+
+// DeletePost soft-deletes the user's post, deletes its attachments and removes it from followers' feeds.
+// Reposts of a deleted post stay in feeds but no longer show its content.
+func DeletePost(ctx context.Context, userID, postID string) error {
+	tx, err := db.WriteDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var repostOf sql.NullString
+	err = tx.QueryRowContext(ctx, `
+		UPDATE posts SET deleted_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND author_user_id = $2 AND deleted_at IS NULL
+		RETURNING repost_of
+	`, postID, userID).Scan(&repostOf)
+	if err == sql.ErrNoRows {
+		return postOwnershipError(ctx, postID)
+	}
+	if err != nil {
+		return err
+	}
+	if repostOf.Valid {
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE posts SET repost_count = repost_count - 1 WHERE id = $1", repostOf.String); err != nil {
+			return err
+		}
+	}
+	mediaKeys, err := deletePostMedia(ctx, tx, postID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	deleteMediaFiles(ctx, mediaKeys)
+	redisClient.Del(ctx, postKeyPrefix+postID)
+	if repostOf.Valid {
+		dropPostCounter(ctx, repostCountKeyPrefix, repostOf.String)
+	}
+
+	go PublishPostEvent(ws.PostFeedPostedMessage{
+		Event:        ws.EventPostDeleted,
+		PostID:       postID,
+		AuthorUserID: userID,
+	})
+	return nil
+}
+
+// postOwnershipError tells a missing post apart from someone else's post after a guarded write matched nothing
+func postOwnershipError(ctx context.Context, postID string) error {
+	var exists bool
+	err := db.WriteDB.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM posts WHERE id = $1 AND deleted_at IS NULL)", postID,
+	).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return errors.ErrNotPostAuthor
+	}
+	return errors.ErrPostNotFound
+}
+
+// deleteUserPosts hard-deletes the user's posts and returns their IDs, so that the cached bodies can be dropped
+func deleteUserPosts(ctx context.Context, tx *sql.Tx, userID string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, "DELETE FROM posts WHERE author_user_id = $1 RETURNING id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var postIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		postIDs = append(postIDs, id)
+	}
+	return postIDs, rows.Err()
+}
+
+func formatNullTime(t sql.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.Format(time.RFC3339Nano)
+}
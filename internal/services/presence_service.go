@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"log"
+	"social/internal/db"
+	"social/internal/models"
+	"social/internal/ws"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// A user is online while some replica holds a socket of theirs. presence:{user_id} is a sorted set
+// of replica IDs scored by the time their claim expires: a replica adds itself when the user's first
+// socket connects, refreshes the claim on presence.ping and removes it when the last socket leaves.
+// Claims of a crashed replica simply expire.
+const (
+	presenceKeyPrefix = "presence:"  // Replicas holding the user's sockets, scored by claim expiry
+	lastSeenKeyPrefix = "last_seen:" // Unix time the user was last seen online
+	typingKeyPrefix   = "typing:"    // Rate limit of typing.start per sender and recipient
+	presenceTTL       = 60 * time.Second
+	typingInterval    = 3 * time.Second
+)
+
+// replicaID identifies this process in presence sets
+var replicaID = randomToken()
+
+// UserConnected runs when the user's first socket connects to this replica
+func UserConnected(userID string) {
+	SubscribeUserEvents(userID)
+	markOnline(context.Background(), userID)
+}
+
+// UserDisconnected runs when the user's last socket on this replica is gone
+func UserDisconnected(userID string) {
+	UnsubscribeUserEvents(userID)
+	ctx := context.Background()
+	pipe := redisClient.Pipeline()
+	pipe.ZRem(ctx, presenceKeyPrefix+userID, replicaID)
+	pipe.Set(ctx, lastSeenKeyPrefix+userID, time.Now().Unix(), 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("UserDisconnected: failed to update presence of user %s: %v", userID, err)
+	}
+}
+
+func markOnline(ctx context.Context, userID string) {
+	now := time.Now()
+	key := presenceKeyPrefix + userID
+	pipe := redisClient.Pipeline()
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now.Add(presenceTTL).Unix()), Member: replicaID})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Unix(), 10))
+	pipe.Expire(ctx, key, presenceTTL)
+	pipe.Set(ctx, lastSeenKeyPrefix+userID, now.Unix(), 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("markOnline: failed to update presence of user %s: %v", userID, err)
+	}
+}
+
+// HandleClientMessage processes a frame received from one of the user's sockets
+func HandleClientMessage(userID, sessionID string, msg ws.InboundMessage) {
+	ctx := context.Background()
+	switch msg.Type {
+	case ws.InboundPresencePing:
+		markOnline(ctx, userID)
+	case ws.InboundTypingStart, ws.InboundTypingStop:
+		relayTyping(ctx, userID, msg)
+	default:
+		log.Printf("HandleClientMessage: unknown frame type %q from user %s", msg.Type, userID)
+	}
+}
+
+// relayTyping forwards a typing event to the dialog partner when the users already have a conversation.
+// typing.start is relayed at most once per typingInterval for a pair of users; typing.stop always goes
+// through and resets the limit.
+func relayTyping(ctx context.Context, userID string, msg ws.InboundMessage) {
+	if _, err := uuid.Parse(msg.To); err != nil || msg.To == userID {
+		return
+	}
+	key := typingKeyPrefix + userID + ":" + msg.To
+	event := ws.TypingMessage{Event: ws.EventTypingStop, FromUserID: userID}
+	if msg.Type == ws.InboundTypingStart {
+		allowed, err := redisClient.SetNX(ctx, key, 1, typingInterval).Result()
+		if err != nil {
+			log.Printf("relayTyping: failed to check rate limit of user %s: %v", userID, err)
+			return
+		}
+		if !allowed {
+			return
+		}
+		event.Event = ws.EventTypingStart
+	} else {
+		redisClient.Del(ctx, key)
+	}
+
+	// Typing is only relayed within an existing conversation, so it cannot be used to probe arbitrary users
+	var exists bool
+	err := db.CitusDB.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM conversations WHERE user_id = $1 AND partner_id = $2)", userID, msg.To,
+	).Scan(&exists)
+	if err != nil {
+		log.Printf("relayTyping: failed to check conversation of user %s: %v", userID, err)
+		return
+	}
+	if !exists {
+		return
+	}
+	blocked, err := IsBlockedBetween(ctx, userID, msg.To)
+	if err != nil {
+		log.Printf("relayTyping: failed to check blocks of user %s: %v", userID, err)
+		return
+	}
+	if blocked {
+		return
+	}
+	publishUserEvent(msg.To, "", event)
+}
+
+// GetPresence returns whether a user is online and when they were last seen.
+// Users blocked in either direction appear offline with no last seen time.
+func GetPresence(ctx context.Context, viewerID, userID string) (*models.Presence, error) {
+	if _, err := GetUserByID(userID); err != nil {
+		return nil, err
+	}
+	presence := &models.Presence{UserID: userID}
+	if viewerID != userID {
+		blocked, err := IsBlockedBetween(ctx, viewerID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if blocked {
+			return presence, nil
+		}
+	}
+
+	now := time.Now().Unix()
+	pipe := redisClient.Pipeline()
+	online := pipe.ZCount(ctx, presenceKeyPrefix+userID, strconv.FormatInt(now, 10), "+inf")
+	lastSeen := pipe.Get(ctx, lastSeenKeyPrefix+userID)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	presence.Online = online.Val() > 0
+	if seen, err := lastSeen.Int64(); err == nil {
+		t := time.Unix(seen, 0).UTC()
+		presence.LastSeen = &t
+	}
+	return presence, nil
+}
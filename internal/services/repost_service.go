@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"social/internal/db"
+	"social/internal/models"
+	"social/internal/ws"
+)
+
+const repostCountKeyPrefix = "post_reposts:" // Repost counter of a post, cached from posts.repost_count
+
+// CreateRepost shares a post the user can see with their friends, with an optional comment.
+// Reposting a repost shares the original post. The repost is fanned out like a new post.
+func CreateRepost(ctx context.Context, userID, postID, text string) (*models.Post, error) {
+	original, err := visiblePost(ctx, userID, postID)
+	if err != nil {
+		return nil, err
+	}
+	if original.RepostOfID != "" {
+		if original, err = visiblePost(ctx, userID, original.RepostOfID); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := db.WriteDB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	post, err := scanPost(tx.QueryRowContext(ctx, `
+		INSERT INTO posts (author_user_id, text, repost_of) VALUES ($1, $2, $3)
+		RETURNING `+postColumns,
+		userID, text, original.ID))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE posts SET repost_count = repost_count + 1 WHERE id = $1", original.ID); err != nil {
+		return nil, err
+	}
+	mentioned, err := indexPostText(ctx, tx, post.ID, text)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	cachePost(ctx, post)
+	dropPostCounter(ctx, repostCountKeyPrefix, original.ID)
+	notifyMentions(post, mentioned)
+
+	go PublishPostEvent(ws.PostFeedPostedMessage{
+		PostID:       post.ID,
+		PostText:     post.Text,
+		AuthorUserID: userID,
+		RepostOf:     original.ID,
+		CreatedAt:    post.CreatedAt,
+	})
+
+	posts, err := decoratePosts(ctx, userID, []models.Post{post})
+	if err != nil {
+		return nil, err
+	}
+	return &posts[0], nil
+}
+
+// decoratePosts fills in counters, the viewer's likes, attachments and the content of reposted posts
+func decoratePosts(ctx context.Context, viewerID string, posts []models.Post) ([]models.Post, error) {
+	posts, err := withCounters(ctx, viewerID, posts)
+	if err != nil {
+		return nil, err
+	}
+	if posts, err = withAttachments(ctx, posts); err != nil {
+		return nil, err
+	}
+	return withReposted(ctx, viewerID, posts)
+}
+
+// withReposted embeds the original post into reposts. Originals that were deleted or are hidden
+// from the viewer by a block are left out and the repost is marked accordingly.
+func withReposted(ctx context.Context, viewerID string, posts []models.Post) ([]models.Post, error) {
+	var originalIDs []string
+	for _, post := range posts {
+		if post.RepostOfID != "" {
+			originalIDs = append(originalIDs, post.RepostOfID)
+		}
+	}
+	if len(originalIDs) == 0 {
+		return posts, nil
+	}
+
+	originals, err := loadPosts(ctx, originalIDs)
+	if err != nil {
+		return nil, err
+	}
+	if originals, err = withCounters(ctx, viewerID, originals); err != nil {
+		return nil, err
+	}
+	if originals, err = withAttachments(ctx, originals); err != nil {
+		return nil, err
+	}
+	var blocked map[string]struct{}
+	if viewerID != "" {
+		if blocked, err = blockedPeers(ctx, viewerID); err != nil {
+			return nil, err
+		}
+	}
+	byID := make(map[string]models.Post, len(originals))
+	for _, original := range originals {
+		if _, hidden := blocked[original.AuthorUserID]; !hidden {
+			byID[original.ID] = original
+		}
+	}
+
+	for i := range posts {
+		if posts[i].RepostOfID == "" {
+			continue
+		}
+		if original, ok := byID[posts[i].RepostOfID]; ok {
+			posts[i].RepostOf = &original
+		} else {
+			posts[i].RepostUnavailable = true
+		}
+	}
+	return posts, nil
+}
+
+// uncountUserReposts takes the user's live reposts off the counters of the original posts and returns those posts
+func uncountUserReposts(ctx context.Context, tx *sql.Tx, userID string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE posts SET repost_count = posts.repost_count - reposts.n
+		FROM (
+			SELECT repost_of, COUNT(*) AS n FROM posts
+			WHERE author_user_id = $1 AND repost_of IS NOT NULL AND deleted_at IS NULL
+			GROUP BY repost_of
+		) reposts
+		WHERE posts.id = reposts.repost_of
+		RETURNING posts.id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var postIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		postIDs = append(postIDs, id)
+	}
+	return postIDs, rows.Err()
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"social/internal/db"
+	"social/internal/models"
+	"social/internal/rabbit"
+	"time"
+)
+
+const (
+	SuggestionsQueue         = "friend_suggestions"
+	suggestionsKeyPrefix     = "friend_suggestions:"
+	suggestionsTTL           = time.Hour
+	suggestionsLimit         = 100 // Number of suggestions kept per user
+	suggestionsFollowerBatch = 500
+)
+
+// SuggestionsRefreshTask asks the suggestions worker to recompute suggestions for users whose friend graph changed
+type SuggestionsRefreshTask struct {
+	UserIDs []string `json:"user_ids"`
+}
+
+// GetFriendSuggestions returns a page of friend-of-friend suggestions ranked by mutual friends and shared city
+func GetFriendSuggestions(ctx context.Context, userID string, offset, limit int) ([]models.FriendSuggestion, error) {
+	var suggestions []models.FriendSuggestion
+	cached, err := redisClient.Get(ctx, suggestionsKeyPrefix+userID).Bytes()
+	if err == nil && json.Unmarshal(cached, &suggestions) == nil {
+		return pageSuggestions(suggestions, offset, limit), nil
+	}
+
+	suggestions, err = RefreshFriendSuggestions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return pageSuggestions(suggestions, offset, limit), nil
+}
+
+// RefreshFriendSuggestions recomputes the user's suggestions from the friends table and caches them
+func RefreshFriendSuggestions(ctx context.Context, userID string) ([]models.FriendSuggestion, error) {
+	query := `
+		SELECT u.id, u.first_name, u.last_name, u.birthdate, u.biography, u.city,
+			COUNT(*) AS mutual_friends,
+			COALESCE(u.city = me.city, false) AS same_city
+		FROM friends f1
+		JOIN friends f2 ON f2.user_id = f1.friend_id
+		JOIN users u ON u.id = f2.friend_id
+		JOIN users me ON me.id = f1.user_id
+		WHERE f1.user_id = $1
+		AND f2.friend_id <> $1
+		AND NOT EXISTS (SELECT 1 FROM friends f WHERE f.user_id = $1 AND f.friend_id = u.id)
+		AND NOT EXISTS (
+			SELECT 1 FROM blocks
+			WHERE (blocks.blocker_id = $1 AND blocks.blocked_id = u.id)
+			OR (blocks.blocker_id = u.id AND blocks.blocked_id = $1)
+		)
+		GROUP BY u.id, me.city
+		ORDER BY mutual_friends DESC, same_city DESC, u.id
+		LIMIT $2
+	`
+	rows, err := db.ReadDB.QueryContext(ctx, query, userID, suggestionsLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []models.FriendSuggestion{}
+	for rows.Next() {
+		var s models.FriendSuggestion
+		err := rows.Scan(&s.ID, &s.FirstName, &s.LastName, &s.Birthdate, &s.Biography, &s.City, &s.MutualFriends, &s.SameCity)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if data, err := json.Marshal(suggestions); err == nil {
+		redisClient.Set(ctx, suggestionsKeyPrefix+userID, data, suggestionsTTL)
+	}
+	return suggestions, nil
+}
+
+// ProcessSuggestionsRefresh handles a SuggestionsRefreshTask. Suggestions of the changed users are
+// recomputed right away; users who have them as friends only get their cache dropped, since their
+// friend-of-friend set changed too but may be large.
+func ProcessSuggestionsRefresh(ctx context.Context, task SuggestionsRefreshTask) {
+	for _, userID := range task.UserIDs {
+		if _, err := RefreshFriendSuggestions(ctx, userID); err != nil {
+			log.Printf("ProcessSuggestionsRefresh: failed to refresh suggestions of user %s: %v", userID, err)
+		}
+		followerIDs, err := GetFriendIDs(userID)
+		if err != nil {
+			log.Printf("ProcessSuggestionsRefresh: failed to load followers of user %s: %v", userID, err)
+			continue
+		}
+		for i := 0; i < len(followerIDs); i += suggestionsFollowerBatch {
+			end := min(i+suggestionsFollowerBatch, len(followerIDs))
+			keys := make([]string, 0, end-i)
+			for _, id := range followerIDs[i:end] {
+				keys = append(keys, suggestionsKeyPrefix+id)
+			}
+			redisClient.Del(ctx, keys...)
+		}
+	}
+}
+
+// scheduleSuggestionsRefresh queues a background refresh after a friend graph change
+func scheduleSuggestionsRefresh(ctx context.Context, userIDs ...string) {
+	// Drop stale entries right away so reads never serve suggestions for existing friends
+	keys := make([]string, len(userIDs))
+	for i, id := range userIDs {
+		keys[i] = suggestionsKeyPrefix + id
+	}
+	redisClient.Del(ctx, keys...)
+
+	if err := rabbit.PublishJSON(SuggestionsQueue, SuggestionsRefreshTask{UserIDs: userIDs}); err != nil {
+		log.Printf("scheduleSuggestionsRefresh: failed to publish refresh for %v: %v", userIDs, err)
+	}
+}
+
+func pageSuggestions(suggestions []models.FriendSuggestion, offset, limit int) []models.FriendSuggestion {
+	if offset >= len(suggestions) {
+		return []models.FriendSuggestion{}
+	}
+	end := min(offset+limit, len(suggestions))
+	return suggestions[offset:end]
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"social/internal/db"
+	"social/internal/models"
+	"social/internal/pagination"
+	"social/internal/ws"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const maxTagLength = 64
+
+var (
+	// A tag or mention starts at the beginning of the text or after a character that cannot be part of a word
+	tagPattern     = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_])#([\p{L}\p{N}_]+)`)
+	mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_])@([0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12})`)
+	validTag       = regexp.MustCompile(`^[\p{L}\p{N}_]+$`)
+)
+
+// NormalizeTag lowercases a tag and strips the leading '#'. It returns "" for a malformed tag.
+func NormalizeTag(tag string) string {
+	tag = strings.ToLower(strings.TrimPrefix(tag, "#"))
+	if len(tag) > maxTagLength || !validTag.MatchString(tag) {
+		return ""
+	}
+	return tag
+}
+
+// parseTags returns the distinct normalized #tags of a text
+func parseTags(text string) []string {
+	return distinctMatches(tagPattern, text, NormalizeTag)
+}
+
+// parseMentions returns the distinct user IDs mentioned as @user_id in a text
+func parseMentions(text string) []string {
+	return distinctMatches(mentionPattern, text, strings.ToLower)
+}
+
+func distinctMatches(pattern *regexp.Regexp, text string, normalize func(string) string) []string {
+	seen := make(map[string]struct{})
+	values := []string{}
+	for _, match := range pattern.FindAllStringSubmatch(text, -1) {
+		value := normalize(match[1])
+		if _, dup := seen[value]; value == "" || dup {
+			continue
+		}
+		seen[value] = struct{}{}
+		values = append(values, value)
+	}
+	return values
+}
+
+// indexPostText stores the tags and mentions of a new or edited post, replacing previous ones.
+// It returns the users who were not mentioned in the post before.
+func indexPostText(ctx context.Context, tx *sql.Tx, postID, text string) ([]string, error) {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM post_tags WHERE post_id = $1", postID); err != nil {
+		return nil, err
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO post_tags (post_id, tag, created_at)
+		SELECT posts.id, tags.tag, posts.created_at
+		FROM posts, unnest($2::text[]) AS tags(tag)
+		WHERE posts.id = $1
+	`, postID, pq.Array(parseTags(text)))
+	if err != nil {
+		return nil, err
+	}
+
+	mentions := parseMentions(text)
+	_, err = tx.ExecContext(ctx,
+		"DELETE FROM post_mentions WHERE post_id = $1 AND NOT (user_id = ANY($2::uuid[]))", postID, pq.Array(mentions))
+	if err != nil {
+		return nil, err
+	}
+	// Unknown user IDs are ignored
+	rows, err := tx.QueryContext(ctx, `
+		INSERT INTO post_mentions (post_id, user_id)
+		SELECT $1, users.id FROM users WHERE users.id = ANY($2::uuid[])
+		ON CONFLICT DO NOTHING
+		RETURNING user_id
+	`, postID, pq.Array(mentions))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var mentioned []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		mentioned = append(mentioned, id)
+	}
+	return mentioned, rows.Err()
+}
+
+// notifyMentions tells mentioned users about a post, whether or not they are friends with the author
+func notifyMentions(post models.Post, userIDs []string) {
+	recipients := make([]string, 0, len(userIDs))
+	for _, id := range userIDs {
+		if id != post.AuthorUserID {
+			recipients = append(recipients, id)
+		}
+	}
+	if len(recipients) == 0 {
+		return
+	}
+	event := ws.PostMentionMessage{
+		Event:        ws.EventPostMentioned,
+		PostID:       post.ID,
+		PostText:     post.Text,
+		AuthorUserID: post.AuthorUserID,
+	}
+	for _, id := range ExcludeBlocked(post.AuthorUserID, recipients) {
+		publishUserEvent(id, "", event)
+	}
+}
+
+// GetTagPosts returns the page of posts with a tag that follows the cursor, newest first.
+// Posts of users blocked in either direction are hidden.
+func GetTagPosts(ctx context.Context, viewerID, tag string, after *pagination.Cursor, limit int) (models.PostPage, error) {
+	query := `
+		SELECT ` + postColumns + `
+		FROM post_tags
+		JOIN posts ON posts.id = post_tags.post_id
+		WHERE post_tags.tag = $1
+		AND posts.deleted_at IS NULL
+		AND NOT EXISTS (
+			SELECT 1 FROM blocks
+			WHERE (blocks.blocker_id = $2 AND blocks.blocked_id = posts.author_user_id)
+			OR (blocks.blocker_id = posts.author_user_id AND blocks.blocked_id = $2)
+		)`
+	args := []any{tag, viewerID}
+	if after != nil {
+		query += " AND (post_tags.created_at, post_tags.post_id) < ($3::timestamp, $4::uuid)"
+		args = append(args, after.CreatedAt, after.ID)
+	}
+	query += " ORDER BY post_tags.created_at DESC, post_tags.post_id DESC LIMIT $" + strconv.Itoa(len(args)+1)
+	args = append(args, limit)
+
+	rows, err := db.ReadDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return models.PostPage{}, err
+	}
+	posts, err := scanPosts(rows)
+	if err != nil {
+		return models.PostPage{}, err
+	}
+	if posts, err = decoratePosts(ctx, viewerID, posts); err != nil {
+		return models.PostPage{}, err
+	}
+	return postPage(posts, limit), nil
+}
@@ -1,7 +1,8 @@
 package services
 
 import (
-	"fmt"
+	"context"
+	"database/sql"
 	"social/internal/db"
 	"social/internal/errors"
 	"social/internal/models"
@@ -25,42 +26,51 @@ func RegisterUser(user *models.User) (string, error) {
 	return userID, nil
 }
 
-func LoginUser(credentials *models.Credentials) (string, error) {
+func LoginUser(ctx context.Context, credentials *models.Credentials, userAgent, ip string) (*models.TokenPair, error) {
 	var storedPassword string
 	err := db.WriteDB.QueryRow("SELECT password FROM users WHERE id = $1", credentials.ID).Scan(&storedPassword)
+	if err == sql.ErrNoRows {
+		return nil, errors.ErrUserNotFound
+	}
 	if err != nil {
-		return "", errors.ErrUserNotFound
+		return nil, err
 	}
 	if !utils.CheckPasswordHash(credentials.Password, storedPassword) {
-		return "", errors.ErrInvalidCredentials
+		return nil, errors.ErrInvalidCredentials
 	}
-	// Generate token (for simplicity, using user ID as token)
-	return credentials.ID, nil
+	return StartSession(ctx, credentials.ID, userAgent, ip)
 }
 
 func GetUserByID(id string) (*models.User, error) {
 	_, err := uuid.Parse(id)
 	if err != nil {
-		return nil, fmt.Errorf("invalid UUID format: %w", err)
+		return nil, errors.ErrInvalidUUID.Wrap(err)
 	}
 
 	var user models.User
 	err = db.ReadDB.QueryRow("SELECT id, first_name, last_name, birthdate, biography, city FROM users WHERE id = $1", id).Scan(
 		&user.ID, &user.FirstName, &user.LastName, &user.Birthdate, &user.Biography, &user.City)
+	if err == sql.ErrNoRows {
+		return nil, errors.ErrUserNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func SearchUsers(firstNamePrefix, lastNamePrefix string) ([]models.User, error) {
+// SearchUsers finds users by name prefixes. When viewerID is set, users who blocked the viewer are hidden.
+func SearchUsers(firstNamePrefix, lastNamePrefix, viewerID string) ([]models.User, error) {
 	query := `
 		SELECT id, first_name, last_name, birthdate, biography, city 
 		FROM users 
 		WHERE first_name LIKE $1 AND last_name LIKE $2 
+		AND ($3 = '' OR NOT EXISTS (
+			SELECT 1 FROM blocks WHERE blocks.blocker_id = users.id AND blocks.blocked_id = NULLIF($3, '')::uuid
+		))
 		ORDER BY id
 	`
-	rows, err := db.ReadDB.Query(query, firstNamePrefix+"%", lastNamePrefix+"%")
+	rows, err := db.ReadDB.Query(query, firstNamePrefix+"%", lastNamePrefix+"%", viewerID)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"social/internal/db"
+	"social/internal/models"
+)
+
+// GetUserView returns the profile of userID enriched with its relationship to the viewer.
+// Anonymous viewers and users viewing their own profile get the plain profile.
+func GetUserView(ctx context.Context, viewerID, userID string, mutualLimit int) (*models.UserView, error) {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	view := &models.UserView{User: *user}
+	if viewerID == "" || viewerID == userID {
+		return view, nil
+	}
+
+	view.FriendshipStatus, err = friendshipStatus(ctx, viewerID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if view.FriendshipStatus == models.FriendshipBlocked {
+		return view, nil
+	}
+
+	err = db.ReadDB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM friends a
+		JOIN friends b ON b.friend_id = a.friend_id
+		WHERE a.user_id = $1 AND b.user_id = $2
+	`, viewerID, userID).Scan(&view.MutualFriendsCount)
+	if err != nil {
+		return nil, err
+	}
+	if view.MutualFriendsCount == 0 || mutualLimit <= 0 {
+		return view, nil
+	}
+
+	rows, err := db.ReadDB.QueryContext(ctx, `
+		SELECT u.id, u.first_name, u.last_name, u.birthdate, u.biography, u.city
+		FROM friends a
+		JOIN friends b ON b.friend_id = a.friend_id
+		JOIN users u ON u.id = a.friend_id
+		WHERE a.user_id = $1 AND b.user_id = $2
+		ORDER BY u.last_name, u.first_name, u.id
+		LIMIT $3
+	`, viewerID, userID, mutualLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var friend models.User
+		if err := rows.Scan(&friend.ID, &friend.FirstName, &friend.LastName, &friend.Birthdate, &friend.Biography, &friend.City); err != nil {
+			return nil, err
+		}
+		view.MutualFriends = append(view.MutualFriends, friend)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return view, nil
+}
+
+// friendshipStatus resolves how userID relates to the viewer. Blocks take precedence over friendship.
+func friendshipStatus(ctx context.Context, viewerID, userID string) (string, error) {
+	var blocked, friend, pendingOut, pendingIn bool
+	err := db.ReadDB.QueryRowContext(ctx, `
+		SELECT
+			EXISTS (SELECT 1 FROM blocks
+				WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)),
+			EXISTS (SELECT 1 FROM friends WHERE user_id = $1 AND friend_id = $2),
+			EXISTS (SELECT 1 FROM friend_requests
+				WHERE from_user_id = $1 AND to_user_id = $2 AND status = 'pending'),
+			EXISTS (SELECT 1 FROM friend_requests
+				WHERE from_user_id = $2 AND to_user_id = $1 AND status = 'pending')
+	`, viewerID, userID).Scan(&blocked, &friend, &pendingOut, &pendingIn)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case blocked:
+		return models.FriendshipBlocked, nil
+	case friend:
+		return models.FriendshipFriend, nil
+	case pendingOut:
+		return models.FriendshipPendingOut, nil
+	case pendingIn:
+		return models.FriendshipPendingIn, nil
+	default:
+		return models.FriendshipNone, nil
+	}
+}
@@ -0,0 +1,81 @@
+package validation
+
+import "social/internal/models"
+
+// User validates a registration payload
+func User(user *models.User) error {
+	var v Validator
+	if v.Required("first_name", user.FirstName) {
+		v.MaxLength("first_name", user.FirstName, MaxNameLength)
+	}
+	if v.Required("last_name", user.LastName) {
+		v.MaxLength("last_name", user.LastName, MaxNameLength)
+	}
+	if v.Required("birthdate", user.Birthdate) {
+		v.Birthdate("birthdate", user.Birthdate)
+	}
+	v.MaxLength("biography", user.Biography, MaxBiographyLength)
+	v.MaxLength("city", user.City, MaxCityLength)
+	v.Password("password", user.Password)
+	if user.ID != "" {
+		v.Add("id", "is assigned by the server")
+	}
+	return v.Err()
+}
+
+// UserUpdate validates a partial profile update; only present fields are checked
+func UserUpdate(update *models.UserUpdate) error {
+	var v Validator
+	if update.FirstName != nil && v.Required("first_name", *update.FirstName) {
+		v.MaxLength("first_name", *update.FirstName, MaxNameLength)
+	}
+	if update.LastName != nil && v.Required("last_name", *update.LastName) {
+		v.MaxLength("last_name", *update.LastName, MaxNameLength)
+	}
+	if update.Birthdate != nil {
+		v.Birthdate("birthdate", *update.Birthdate)
+	}
+	if update.Biography != nil {
+		v.MaxLength("biography", *update.Biography, MaxBiographyLength)
+	}
+	if update.City != nil {
+		v.MaxLength("city", *update.City, MaxCityLength)
+	}
+	return v.Err()
+}
+
+// Credentials validates a login payload
+func Credentials(credentials *models.Credentials) error {
+	var v Validator
+	if v.Required("id", credentials.ID) {
+		v.UUID("id", credentials.ID)
+	}
+	v.Required("password", credentials.Password)
+	return v.Err()
+}
+
+// PasswordChange validates a password change payload
+func PasswordChange(change *models.PasswordChange) error {
+	var v Validator
+	v.Required("old_password", change.OldPassword)
+	if v.Password("new_password", change.NewPassword) && change.NewPassword == change.OldPassword {
+		v.Add("new_password", "must differ from the old password")
+	}
+	return v.Err()
+}
+
+// Text validates a required free-text field such as a post or a message body
+func Text(field, text string, maxLength int) error {
+	var v Validator
+	if v.Required(field, text) {
+		v.MaxLength(field, text, maxLength)
+	}
+	return v.Err()
+}
+
+// Required validates that a single field is present
+func Required(field, value string) error {
+	var v Validator
+	v.Required(field, value)
+	return v.Err()
+}
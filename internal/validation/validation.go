@@ -0,0 +1,165 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const (
+	MaxNameLength      = 100
+	MaxCityLength      = 100
+	MaxBiographyLength = 1000
+	MaxPostLength      = 5000
+	MaxMessageLength   = 4000
+	MaxCommentLength   = 2000
+	MinPasswordLength  = 8
+	MaxPasswordLength  = 72 // bcrypt ignores everything past 72 bytes
+	MinAge             = 14
+	MaxAge             = 120
+)
+
+// FieldError describes a problem with a single payload field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors is a list of field errors; it is returned as an error when validation fails
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validator accumulates field errors
+type Validator struct {
+	errs Errors
+}
+
+// Err returns the accumulated errors, or nil when the payload is valid
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+// Add records an error for the field
+func (v *Validator) Add(field, message string) {
+	v.errs = append(v.errs, FieldError{Field: field, Message: message})
+}
+
+// Required checks that the value is not blank
+func (v *Validator) Required(field, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		v.Add(field, "is required")
+		return false
+	}
+	return true
+}
+
+// MaxLength checks that the value has at most max characters
+func (v *Validator) MaxLength(field, value string, max int) bool {
+	if utf8.RuneCountInString(value) > max {
+		v.Add(field, fmt.Sprintf("must be at most %d characters", max))
+		return false
+	}
+	return true
+}
+
+// UUID checks that the value is a well-formed UUID
+func (v *Validator) UUID(field, value string) bool {
+	if _, err := uuid.Parse(value); err != nil {
+		v.Add(field, "must be a valid UUID")
+		return false
+	}
+	return true
+}
+
+// Birthdate checks the YYYY-MM-DD format and that the age is within [MinAge, MaxAge]
+func (v *Validator) Birthdate(field, value string) bool {
+	birthdate, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		v.Add(field, "must be a date in YYYY-MM-DD format")
+		return false
+	}
+	now := time.Now()
+	if birthdate.After(now.AddDate(-MinAge, 0, 0)) {
+		v.Add(field, fmt.Sprintf("age must be at least %d", MinAge))
+		return false
+	}
+	if birthdate.Before(now.AddDate(-MaxAge, 0, 0)) {
+		v.Add(field, fmt.Sprintf("age must be at most %d", MaxAge))
+		return false
+	}
+	return true
+}
+
+// Password checks length limits and that the password mixes letters and digits
+func (v *Validator) Password(field, value string) bool {
+	if len(value) < MinPasswordLength {
+		v.Add(field, fmt.Sprintf("must be at least %d characters", MinPasswordLength))
+		return false
+	}
+	if len(value) > MaxPasswordLength {
+		v.Add(field, fmt.Sprintf("must be at most %d bytes", MaxPasswordLength))
+		return false
+	}
+	var hasLetter, hasDigit bool
+	for _, r := range value {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	if !hasLetter || !hasDigit {
+		v.Add(field, "must contain both letters and digits")
+		return false
+	}
+	return true
+}
+
+// DecodeJSON decodes a request body into dst, rejecting unknown fields and trailing data.
+// Decoding problems that can be attributed to a field are returned as Errors.
+func DecodeJSON(body io.Reader, dst any) error {
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return decodeError(err)
+	}
+	if dec.More() {
+		return Errors{{Field: "body", Message: "must contain a single JSON object"}}
+	}
+	return nil
+}
+
+func decodeError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr):
+		return Errors{{Field: typeErr.Field, Message: "must be of type " + typeErr.Type.String()}}
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return Errors{{Field: "body", Message: "is not valid JSON"}}
+	case errors.Is(err, io.EOF):
+		return Errors{{Field: "body", Message: "is required"}}
+	}
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return Errors{{Field: strings.Trim(field, `"`), Message: "is not allowed"}}
+	}
+	return Errors{{Field: "body", Message: err.Error()}}
+}
@@ -5,13 +5,23 @@ import (
 	"log"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
 type Client struct {
-	UserID string
-	Conn   *websocket.Conn
+	UserID    string
+	SessionID string
+	Conn      *websocket.Conn
+	writeMu   sync.Mutex
+}
+
+// write sends a text frame; gorilla/websocket allows only one concurrent writer per connection
+func (c *Client) write(msg []byte) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.Conn.WriteMessage(websocket.TextMessage, msg)
 }
 
 var (
@@ -20,87 +30,348 @@ var (
 	}
 	clients      = make(map[string]map[*Client]struct{}) // userID -> set of clients
 	clientsMutex sync.RWMutex
+
+	// recipientFilter drops recipients that must not receive an author's events (e.g. blocked users)
+	recipientFilter = func(authorID string, userIDs []string) []string { return userIDs }
+
+	// userConnected and userDisconnected run when a user's first client connects to this process and
+	// when the last one leaves. They are serialized per user so that they always alternate, while
+	// different users connect and disconnect in parallel.
+	membershipMu     sync.Mutex
+	memberships      = make(map[string]*membership) // userID -> lock, while any goroutine uses it
+	userConnected    = func(userID string) {}
+	userDisconnected = func(userID string) {}
+
+	// inboundHandler processes the frames clients send
+	inboundHandler = func(userID, sessionID string, msg InboundMessage) {}
 )
 
-func ServeWS(w http.ResponseWriter, r *http.Request) {
-	token := r.URL.Query().Get("token")
-	if token == "" {
-		http.Error(w, "Missing token", http.StatusUnauthorized)
-		return
+const (
+	// maxInboundFrame bounds client frames; the inbound protocol only carries small control messages
+	maxInboundFrame = 4096
+	// A client may send inboundRate frames per second on average and inboundBurst at once;
+	// frames over the limit are dropped
+	inboundRate  = 5
+	inboundBurst = 10
+)
+
+// frameLimiter is a token bucket limiting the frames of one connection
+type frameLimiter struct {
+	tokens float64
+	last   time.Time
+}
+
+func newFrameLimiter() *frameLimiter {
+	return &frameLimiter{tokens: inboundBurst, last: time.Now()}
+}
+
+func (l *frameLimiter) allow() bool {
+	now := time.Now()
+	l.tokens = min(inboundBurst, l.tokens+now.Sub(l.last).Seconds()*inboundRate)
+	l.last = now
+	if l.tokens < 1 {
+		return false
 	}
-	userID := token // For now, token is userID
+	l.tokens--
+	return true
+}
 
+// InboundMessage is a frame sent by a client, e.g. {"type": "typing.start", "to": "<user_id>"}
+type InboundMessage struct {
+	Type string `json:"type"`
+	To   string `json:"to,omitempty"`
+}
+
+const (
+	InboundTypingStart  = "typing.start"  // The user started typing to To
+	InboundTypingStop   = "typing.stop"   // The user stopped typing to To
+	InboundPresencePing = "presence.ping" // Keeps the user online; sent periodically by clients
+)
+
+// SetRecipientFilter installs the filter applied to post event recipients
+func SetRecipientFilter(filter func(authorID string, userIDs []string) []string) {
+	recipientFilter = filter
+}
+
+// SetInboundHandler installs the handler of client frames
+func SetInboundHandler(handler func(userID, sessionID string, msg InboundMessage)) {
+	inboundHandler = handler
+}
+
+// SetConnectionHooks installs callbacks for a user's first client connecting to this process and
+// the last one disconnecting, e.g. to subscribe to the user's events from other replicas
+func SetConnectionHooks(connected, disconnected func(userID string)) {
+	userConnected = connected
+	userDisconnected = disconnected
+}
+
+// ServeWS upgrades an authenticated request and serves the user's client until it disconnects
+func ServeWS(w http.ResponseWriter, r *http.Request, userID, sessionID string) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
 		return
 	}
-	client := &Client{UserID: userID, Conn: conn}
+	client := &Client{UserID: userID, SessionID: sessionID, Conn: conn}
 
-	clientsMutex.Lock()
-	if clients[userID] == nil {
-		clients[userID] = make(map[*Client]struct{})
-	}
-	clients[userID][client] = struct{}{}
-	clientsMutex.Unlock()
+	register(client)
 
 	log.Printf("WebSocket connected: user %s", userID)
 
 	go func() {
 		defer func() {
 			conn.Close()
-			clientsMutex.Lock()
-			delete(clients[userID], client)
-			if len(clients[userID]) == 0 {
-				delete(clients, userID)
-			}
-			clientsMutex.Unlock()
+			unregister(client)
 			log.Printf("WebSocket disconnected: user %s", userID)
 		}()
+		conn.SetReadLimit(maxInboundFrame)
+		limiter := newFrameLimiter()
 		for {
-			_, _, err := conn.ReadMessage()
+			msgType, data, err := conn.ReadMessage()
 			if err != nil {
 				break
 			}
+			if msgType != websocket.TextMessage || !limiter.allow() {
+				continue
+			}
+			var msg InboundMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				log.Printf("WebSocket: malformed frame from user %s: %v", userID, err)
+				continue
+			}
+			inboundHandler(userID, client.SessionID, msg)
 		}
 	}()
 }
 
-// PostFeedPostedMessage is the payload for /post/feed/posted
+// membership serializes the connects and disconnects of one user
+type membership struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func lockMembership(userID string) *membership {
+	membershipMu.Lock()
+	m := memberships[userID]
+	if m == nil {
+		m = &membership{}
+		memberships[userID] = m
+	}
+	m.refs++
+	membershipMu.Unlock()
+	m.mu.Lock()
+	return m
+}
+
+func unlockMembership(userID string, m *membership) {
+	m.mu.Unlock()
+	membershipMu.Lock()
+	m.refs--
+	if m.refs == 0 {
+		delete(memberships, userID)
+	}
+	membershipMu.Unlock()
+}
+
+func register(client *Client) {
+	m := lockMembership(client.UserID)
+	defer unlockMembership(client.UserID, m)
+	clientsMutex.Lock()
+	first := clients[client.UserID] == nil
+	if first {
+		clients[client.UserID] = make(map[*Client]struct{})
+	}
+	clients[client.UserID][client] = struct{}{}
+	clientsMutex.Unlock()
+	if first {
+		userConnected(client.UserID)
+	}
+}
+
+func unregister(client *Client) {
+	m := lockMembership(client.UserID)
+	defer unlockMembership(client.UserID, m)
+	clientsMutex.Lock()
+	delete(clients[client.UserID], client)
+	last := len(clients[client.UserID]) == 0
+	if last {
+		delete(clients, client.UserID)
+	}
+	clientsMutex.Unlock()
+	if last {
+		userDisconnected(client.UserID)
+	}
+}
+
+// PostFeedPostedMessage is the payload for /post/feed/posted.
+// Event is empty for new posts and set for changes to an already delivered post.
 type PostFeedPostedMessage struct {
+	Event        string `json:"event,omitempty"`
 	PostID       string `json:"postId"`
 	PostText     string `json:"postText"`
 	AuthorUserID string `json:"author_user_id"`
+	RepostOf     string `json:"repost_of,omitempty"`
+	CreatedAt    string `json:"created_at,omitempty"`
+	UpdatedAt    string `json:"updated_at,omitempty"`
+}
+
+const (
+	EventPostUpdated = "post.updated"
+	EventPostDeleted = "post.deleted"
+)
+
+// FriendRequestMessage is the payload for friend request events
+type FriendRequestMessage struct {
+	Event      string `json:"event"`
+	RequestID  string `json:"request_id"`
+	FromUserID string `json:"from_user_id"`
+	ToUserID   string `json:"to_user_id"`
+}
+
+const (
+	EventFriendRequestReceived = "friend_request.received"
+	EventFriendRequestAccepted = "friend_request.accepted"
+)
+
+// PostLikedMessage is sent to a post's author when someone likes the post
+type PostLikedMessage struct {
+	Event  string `json:"event"`
+	PostID string `json:"post_id"`
+	UserID string `json:"user_id"`
+}
+
+const EventPostLiked = "post.liked"
+
+// CommentPostedMessage is sent to a post's author and thread participants when a comment is added
+type CommentPostedMessage struct {
+	Event        string `json:"event"`
+	CommentID    string `json:"comment_id"`
+	PostID       string `json:"post_id"`
+	ParentID     string `json:"parent_id,omitempty"`
+	AuthorUserID string `json:"author_user_id"`
+	Text         string `json:"text"`
 }
 
+const EventCommentCreated = "comment.created"
+
+// PostMentionMessage is sent to users mentioned in a post
+type PostMentionMessage struct {
+	Event        string `json:"event"`
+	PostID       string `json:"post_id"`
+	PostText     string `json:"post_text"`
+	AuthorUserID string `json:"author_user_id"`
+}
+
+const EventPostMentioned = "post.mentioned"
+
+// DialogMessage is sent to the recipient of a message and to the sender's other devices
+type DialogMessage struct {
+	Event      string    `json:"event"`
+	MessageID  int64     `json:"message_id"`
+	FromUserID string    `json:"from"`
+	ToUserID   string    `json:"to"`
+	Text       string    `json:"text"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+const EventDialogMessage = "dialog.message"
+
+// TypingMessage tells a user that their dialog partner started or stopped typing
+type TypingMessage struct {
+	Event      string `json:"event"`
+	FromUserID string `json:"from"`
+}
+
+const (
+	EventTypingStart = "typing.start"
+	EventTypingStop  = "typing.stop"
+)
+
 // NotifyFriends sends a post event to all friends' websocket clients
 func NotifyFriends(friendIDs []string, post PostFeedPostedMessage) {
+	friendIDs = recipientFilter(post.AuthorUserID, friendIDs)
 	msg, _ := json.Marshal(post)
 	clientsMutex.RLock()
 	defer clientsMutex.RUnlock()
 	for _, fid := range friendIDs {
 		for c := range clients[fid] {
-			go func(cl *Client) {
-				cl.Conn.WriteMessage(websocket.TextMessage, msg)
-			}(c)
+			go c.write(msg)
 		}
 	}
 }
 
 // NotifyFriendsBatch sends a post event to a batch of friends' websocket clients
 func NotifyFriendsBatch(friendIDs []string, post PostFeedPostedMessage) {
+	friendIDs = recipientFilter(post.AuthorUserID, friendIDs)
 	msg, _ := json.Marshal(post)
+	sendToUsers(friendIDs, msg)
+}
+
+// NotifyUser sends an arbitrary event to all websocket clients of a single user
+func NotifyUser(userID string, event any) {
+	msg, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("NotifyUser: failed to marshal event for user %s: %v", userID, err)
+		return
+	}
+	sendToUsers([]string{userID}, msg)
+}
+
+// NotifyUserDevices sends a raw event to the user's websocket clients on this process,
+// except those opened with exceptSessionID (the device the event originates from)
+func NotifyUserDevices(userID, exceptSessionID string, msg []byte) {
 	var targets []*Client
 	clientsMutex.RLock()
-	for _, fid := range friendIDs {
-		for c := range clients[fid] {
+	for c := range clients[userID] {
+		if exceptSessionID == "" || c.SessionID != exceptSessionID {
+			targets = append(targets, c)
+		}
+	}
+	clientsMutex.RUnlock()
+	for _, cl := range targets {
+		go cl.write(msg)
+	}
+}
+
+func sendToUsers(userIDs []string, msg []byte) {
+	var targets []*Client
+	clientsMutex.RLock()
+	for _, uid := range userIDs {
+		for c := range clients[uid] {
+			targets = append(targets, c)
+		}
+	}
+	clientsMutex.RUnlock()
+	for _, cl := range targets {
+		go cl.write(msg)
+	}
+}
+
+// CloseSession disconnects the user's websocket clients opened with the given session
+func CloseSession(userID, sessionID string) {
+	closeClients(userID, func(c *Client) bool { return c.SessionID == sessionID })
+}
+
+// CloseUser disconnects all websocket clients of the user
+func CloseUser(userID string) {
+	closeClients(userID, func(c *Client) bool { return true })
+}
+
+func closeClients(userID string, match func(*Client) bool) {
+	var targets []*Client
+	clientsMutex.RLock()
+	for c := range clients[userID] {
+		if match(c) {
 			targets = append(targets, c)
 		}
 	}
 	clientsMutex.RUnlock()
+	closeMsg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "session revoked")
 	for _, cl := range targets {
-		go func(cl *Client) {
-			cl.Conn.WriteMessage(websocket.TextMessage, msg)
-		}(cl)
+		// The read loop notices the closed connection and unregisters the client
+		cl.writeMu.Lock()
+		cl.Conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+		cl.writeMu.Unlock()
+		cl.Conn.Close()
 	}
 }